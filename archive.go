@@ -0,0 +1,294 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 压缩包安全限制
+var (
+	maxArchiveEntries      = 10000                     // 条目数上限
+	maxArchiveUncompressed = int64(1024 * 1024 * 1024) // 解压后总大小上限 1GB
+	maxCompressionRatio    = uint64(200)               // 单个条目的压缩比上限
+	maxNestedDepth         = 3                         // 嵌套压缩包最多递归处理的层数
+)
+
+// 压缩包因安全原因被拒绝时返回的错误
+var errUnsafeArchive = errors.New("压缩包不安全，已拒绝处理")
+
+// 一个任务的所有解压（含递归解压的嵌套压缩包）共用的剩余额度，嵌套压缩包不会各自获得新的额度
+type extractBudget struct {
+	bytes   int64 // 还可以解压出的字节数
+	entries int   // 还可以解压出的条目数
+}
+
+func newExtractBudget() *extractBudget {
+	return &extractBudget{bytes: maxArchiveUncompressed, entries: maxArchiveEntries}
+}
+
+// 占用一个条目
+func (b *extractBudget) addEntry() error {
+	if b.entries <= 0 {
+		return fmt.Errorf("%w：条目数超过上限 %d", errUnsafeArchive, maxArchiveEntries)
+	}
+	b.entries--
+	return nil
+}
+
+/******************* 压缩包安全检查 *******************/
+// 按剩余额度检查条目路径、类型、数量和声明的解压大小；读取条目时 archive/zip 会校验实际大小与声明一致
+func checkZipArchive(files []*zip.File, budget *extractBudget) error {
+	if len(files) > budget.entries {
+		return fmt.Errorf("%w：条目数 %d 超过上限 %d", errUnsafeArchive, len(files), maxArchiveEntries)
+	}
+
+	total := uint64(0)
+	for _, f := range files {
+		if _, err := sanitizeEntryPath(f.Name); err != nil {
+			return err
+		}
+
+		mode := f.Mode()
+		if mode&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w：不允许包含符号链接 %s", errUnsafeArchive, f.Name)
+		}
+		if !mode.IsDir() && !mode.IsRegular() {
+			return fmt.Errorf("%w：不支持的条目类型 %s", errUnsafeArchive, f.Name)
+		}
+
+		if f.UncompressedSize64 > 0 {
+			if f.CompressedSize64 == 0 || f.UncompressedSize64/f.CompressedSize64 > maxCompressionRatio {
+				return fmt.Errorf("%w：条目 %s 的压缩比超过 %d 倍", errUnsafeArchive, f.Name, maxCompressionRatio)
+			}
+		}
+		total += f.UncompressedSize64
+		if total > uint64(budget.bytes) {
+			return fmt.Errorf("%w：解压后总大小超过 %dMB", errUnsafeArchive, maxArchiveUncompressed/1024/1024)
+		}
+	}
+	return nil
+}
+
+// 规范化包内路径，拒绝绝对路径和包含 .. 的路径，返回以 / 分隔的相对路径
+func sanitizeEntryPath(name string) (string, error) {
+	clean := strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(clean, "/") || filepath.VolumeName(clean) != "" || (len(clean) >= 2 && clean[1] == ':') {
+		return "", fmt.Errorf("%w：不允许使用绝对路径 %s", errUnsafeArchive, name)
+	}
+	for _, part := range strings.Split(clean, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w：路径 %s 越出解压目录", errUnsafeArchive, name)
+		}
+	}
+	return path.Clean(clean), nil
+}
+
+// 将包内路径拼接到解压目录下，并再次确认结果位于目录之内
+func safeJoin(dest, name string) (string, error) {
+	rel, err := sanitizeEntryPath(name)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(dest, filepath.FromSlash(rel))
+	base := filepath.Clean(dest)
+	if target != base && !strings.HasPrefix(target, base+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%w：路径 %s 越出解压目录", errUnsafeArchive, name)
+	}
+	return target, nil
+}
+
+/******************* 解压单个条目 *******************/
+func extractZipEntry(f *zip.File, target string, limit int64) (int64, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	return writeEntryFile(target, rc, limit)
+}
+
+// 最多写入 limit 字节，超出时返回错误，返回实际写入的字节数
+func writeEntryFile(target string, r io.Reader, limit int64) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, err
+	}
+
+	outFile, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer outFile.Close()
+
+	n, err := io.Copy(outFile, io.LimitReader(r, limit+1))
+	if err != nil {
+		return n, err
+	}
+	if n > limit {
+		return n, fmt.Errorf("%w：解压后总大小超过 %dMB", errUnsafeArchive, maxArchiveUncompressed/1024/1024)
+	}
+	return n, outFile.Close()
+}
+
+// 解压失败时给用户的提示
+func unzipErrorMessage(err error) string {
+	if errors.Is(err, errUnsafeArchive) {
+		return "❌ " + err.Error()
+	}
+	return "❌ 解压文件失败: " + err.Error()
+}
+
+/******************* 重建压缩包 *******************/
+// 按原压缩包的条目顺序写出新压缩包：未修改的条目（含目录）原样复制不重新压缩，
+// 修改过的条目沿用原有的文件头（时间、权限、压缩方式、扩展字段），只替换内容；压缩包注释一并保留
+func repackZip(src, workDir string, changed map[string]bool, out io.Writer) error {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w := zip.NewWriter(out)
+	for _, f := range r.File {
+		name, err := sanitizeEntryPath(f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() || !changed[name] {
+			if err := w.Copy(f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := writeZipEntry(w, f, filepath.Join(workDir, filepath.FromSlash(name))); err != nil {
+			return err
+		}
+	}
+
+	if err := w.SetComment(r.Comment); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// 以原条目的文件头写入磁盘上修改后的内容
+func writeZipEntry(w *zip.Writer, f *zip.File, path string) error {
+	content, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	header := f.FileHeader
+	header.Modified = time.Time{} // 沿用原有的时间字段和扩展字段，避免重复写入时间戳
+	header.Extra = stripZip64Extra(header.Extra)
+	entry, err := w.CreateHeader(&header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, content)
+	return err
+}
+
+// 去掉 Zip64 扩展字段，其中记录的是旧内容的大小，需要时由 zip.Writer 重新写入
+func stripZip64Extra(extra []byte) []byte {
+	kept := make([]byte, 0, len(extra))
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra[:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if 4+size > len(extra) {
+			break
+		}
+		if tag != 0x0001 {
+			kept = append(kept, extra[:4+size]...)
+		}
+		extra = extra[4+size:]
+	}
+	return kept
+}
+
+// 补丁记录中被修改的包内路径
+func changedPaths(files []PatchFile) map[string]bool {
+	changed := make(map[string]bool, len(files))
+	for _, f := range files {
+		changed[f.Path] = true
+	}
+	return changed
+}
+
+/******************* 嵌套压缩包 *******************/
+// 解压到独立的临时目录递归处理，有内容被修改时按原结构重建并替换磁盘上的压缩包
+// 返回的补丁中 Entries 记录内层文件的修改，报告中的路径形如 外层.zip!/内层.dat
+func processNestedArchive(path, name, format string, depth int, budget *extractBudget, codes []CodePair, opts *BeautifyOptions) (*BeautifyResult, *PatchFile, error) {
+	inputHash, err := sha256File(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	workDir, err := ioutil.TempDir("", "nested_archive_*")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	if err := extractArchive(format, path, filepath.Base(path), workDir, budget); err != nil {
+		if errors.Is(err, zip.ErrFormat) || errors.Is(err, tar.ErrHeader) || errors.Is(err, gzip.ErrHeader) {
+			// 只是后缀与压缩包相同的普通文件
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	result, err := processDirectoryAt(workDir, name+"!/", depth, budget, codes, opts)
+	if err != nil || len(result.Files) == 0 {
+		return result, nil, err
+	}
+
+	if err := replaceArchive(format, path, filepath.Base(path), workDir, changedPaths(result.Files)); err != nil {
+		return result, nil, err
+	}
+
+	outputHash, err := sha256File(path)
+	if err != nil {
+		return result, nil, err
+	}
+	return result, &PatchFile{
+		Path:         name,
+		InputSHA256:  inputHash,
+		OutputSHA256: outputHash,
+		Patches:      make([]PatchEntry, 0),
+		Entries:      result.Files,
+	}, nil
+}
+
+// 按解压目录重建压缩包并替换 path 处的原文件
+func replaceArchive(format, path, name, workDir string, changed map[string]bool) error {
+	repacked := path + ".repack"
+	out, err := os.Create(repacked)
+	if err != nil {
+		return err
+	}
+	err = repackArchive(format, path, name, workDir, changed, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(repacked, path)
+	}
+	if err != nil {
+		os.Remove(repacked)
+	}
+	return err
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeEntryPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // 为空表示应当拒绝
+	}{
+		{"a/b.dat", "a/b.dat"},
+		{"./a//b.dat", "a/b.dat"},
+		{"a\\b.dat", "a/b.dat"},
+		{"a/./b/../c.dat", ""},
+		{"..foo/b.dat", "..foo/b.dat"},
+		{"../b.dat", ""},
+		{"a/../../b.dat", ""},
+		{"a\\..\\..\\b.dat", ""},
+		{"..", ""},
+		{"/etc/passwd", ""},
+		{"\\windows\\b.dat", ""},
+		{"C:/b.dat", ""},
+		{"c:\\b.dat", ""},
+		{"C:b.dat", ""},
+	}
+	for _, tt := range tests {
+		got, err := sanitizeEntryPath(tt.name)
+		if tt.want == "" {
+			if !errors.Is(err, errUnsafeArchive) {
+				t.Errorf("sanitizeEntryPath(%q) = %q, %v，应当拒绝", tt.name, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("sanitizeEntryPath(%q) = %q, %v，期望 %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// 测试用的压缩包条目
+type testEntry struct {
+	name     string
+	body     []byte
+	mode     os.FileMode
+	linkname string // tar 链接目标
+	typeflag byte   // tar 条目类型，为 0 时按 mode 判断
+}
+
+func buildZip(t *testing.T, entries []testEntry) []*zip.File {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		if e.mode != 0 {
+			header.SetMode(e.mode)
+		}
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(e.body)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.File
+}
+
+func TestCheckZipArchive(t *testing.T) {
+	data := []byte("hello world")
+	tests := []struct {
+		name    string
+		entries []testEntry
+		budget  *extractBudget
+		unsafe  bool
+	}{
+		{"普通文件", []testEntry{{name: "dir/", mode: os.ModeDir | 0755}, {name: "dir/a.dat", body: data}}, nil, false},
+		{"越出目录", []testEntry{{name: "../a.dat", body: data}}, nil, true},
+		{"绝对路径", []testEntry{{name: "/tmp/a.dat", body: data}}, nil, true},
+		{"盘符路径", []testEntry{{name: "C:\\a.dat", body: data}}, nil, true},
+		{"符号链接", []testEntry{{name: "link", body: []byte("/etc/passwd"), mode: os.ModeSymlink | 0777}}, nil, true},
+		{"设备文件", []testEntry{{name: "dev", mode: os.ModeDevice | 0644}}, nil, true},
+		{"压缩比超限", []testEntry{{name: "zero.dat", body: make([]byte, 4<<20)}}, nil, true},
+		{"条目数超限", []testEntry{{name: "a", body: data}, {name: "b", body: data}, {name: "c", body: data}}, &extractBudget{bytes: 1 << 20, entries: 2}, true},
+		{"总大小超限", []testEntry{{name: "a", body: data}, {name: "b", body: data}}, &extractBudget{bytes: int64(len(data)) + 1, entries: 10}, true},
+		{"额度内", []testEntry{{name: "a", body: data}, {name: "b", body: data}}, &extractBudget{bytes: int64(len(data)) * 2, entries: 2}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budget := tt.budget
+			if budget == nil {
+				budget = newExtractBudget()
+			}
+			err := checkZipArchive(buildZip(t, tt.entries), budget)
+			if tt.unsafe && !errors.Is(err, errUnsafeArchive) {
+				t.Fatalf("应当拒绝，实际返回 %v", err)
+			}
+			if !tt.unsafe && err != nil {
+				t.Fatalf("不应拒绝：%v", err)
+			}
+		})
+	}
+}
+
+func writeTar(t *testing.T, path string, compressed bool, entries []testEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	var gw *gzip.Writer
+	if compressed {
+		gw = gzip.NewWriter(f)
+		w = gw
+	}
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: e.typeflag, Linkname: e.linkname}
+		switch {
+		case e.typeflag != 0:
+			header.Size = 0
+		case e.mode.IsDir():
+			header.Typeflag, header.Size = tar.TypeDir, 0
+		default:
+			header.Typeflag = tar.TypeReg
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			tw.Write(e.body)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUntar(t *testing.T) {
+	data := []byte("hello world")
+	tests := []struct {
+		name       string
+		compressed bool
+		entries    []testEntry
+		budget     *extractBudget
+		unsafe     bool
+	}{
+		{"普通文件", false, []testEntry{{name: "dir/", mode: os.ModeDir}, {name: "dir/a.dat", body: data}}, nil, false},
+		{"gzip 压缩", true, []testEntry{{name: "a.dat", body: data}}, nil, false},
+		{"越出目录", false, []testEntry{{name: "../escape.dat", body: data}}, nil, true},
+		{"多层越出目录", false, []testEntry{{name: "a/../../escape.dat", body: data}}, nil, true},
+		{"绝对路径", false, []testEntry{{name: "/tmp/escape.dat", body: data}}, nil, true},
+		{"盘符路径", false, []testEntry{{name: "C:/escape.dat", body: data}}, nil, true},
+		{"符号链接", false, []testEntry{{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"}}, nil, true},
+		{"硬链接", false, []testEntry{{name: "a.dat", body: data}, {name: "link", typeflag: tar.TypeLink, linkname: "a.dat"}}, nil, true},
+		{"设备文件", false, []testEntry{{name: "dev", typeflag: tar.TypeChar}}, nil, true},
+		{"压缩比超限", true, []testEntry{{name: "zero.dat", body: make([]byte, 8<<20)}}, nil, true},
+		{"条目数超限", false, []testEntry{{name: "a", body: data}, {name: "b", body: data}, {name: "c", body: data}}, &extractBudget{bytes: 1 << 20, entries: 2}, true},
+		{"总大小超限", false, []testEntry{{name: "a", body: data}, {name: "b", body: data}}, &extractBudget{bytes: int64(len(data)) + 1, entries: 10}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "test.tar")
+			writeTar(t, src, tt.compressed, tt.entries)
+			dest := filepath.Join(dir, "out")
+
+			budget := tt.budget
+			if budget == nil {
+				budget = newExtractBudget()
+			}
+			err := untar(src, tt.compressed, dest, budget)
+			if tt.unsafe && !errors.Is(err, errUnsafeArchive) {
+				t.Fatalf("应当拒绝，实际返回 %v", err)
+			}
+			if !tt.unsafe && err != nil {
+				t.Fatalf("不应拒绝：%v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "escape.dat")); err == nil {
+				t.Fatal("文件被写到了解压目录之外")
+			}
+		})
+	}
+}
+
+// 压缩比超限时在写入前就停止读取，解压出的字节不超过压缩大小的 maxCompressionRatio 倍
+func TestGunzipRatioLimit(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write(make([]byte, 32<<20))
+	gw.Close()
+	src := filepath.Join(dir, "zero.dat.gz")
+	if err := os.WriteFile(src, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(dir, "out")
+	if err := gunzip(src, "zero.dat.gz", dest, newExtractBudget()); !errors.Is(err, errUnsafeArchive) {
+		t.Fatalf("应当拒绝，实际返回 %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dest, "zero.dat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit := int64(buf.Len()) * int64(maxCompressionRatio); info.Size() > limit+1 {
+		t.Fatalf("写入了 %d 字节，超过上限 %d", info.Size(), limit)
+	}
+}
+
+// 嵌套压缩包与外层共用额度
+func TestExtractBudgetShared(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("x"), 100)
+	src := filepath.Join(dir, "test.tar")
+	writeTar(t, src, false, []testEntry{{name: "a", body: data}})
+
+	budget := &extractBudget{bytes: 150, entries: 10}
+	if err := untar(src, false, filepath.Join(dir, "first"), budget); err != nil {
+		t.Fatal(err)
+	}
+	if budget.bytes != 50 || budget.entries != 9 {
+		t.Fatalf("剩余额度 %d 字节 %d 条目", budget.bytes, budget.entries)
+	}
+	if err := untar(src, false, filepath.Join(dir, "second"), budget); !errors.Is(err, errUnsafeArchive) {
+		t.Fatalf("第二次解压应超出额度，实际返回 %v", err)
+	}
+}
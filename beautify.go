@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 未找到代码对时的处理策略
+const (
+	PolicyStrict  = "strict"  // 严格：任一代码对未找到即终止任务
+	PolicyLenient = "lenient" // 宽松：跳过未找到的代码对并继续
+)
+
+// 美化任务选项
+type BeautifyOptions struct {
+	Policy    string
+	Preview   bool        // 预览模式：只查找不修改，不扣积分
+	Filter    FileFilter  // 压缩包中要处理的目标文件
+	Semantics string      // 多个代码对之间的交换方式，为空时依次交换
+	MultiFile bool        // 多文件模式：收集多个文件后一起处理
+	Control   *JobControl `json:"-"` // 队列中任务的取消信号和进度，预览等场景为 nil
+}
+
+func defaultBeautifyOptions() *BeautifyOptions {
+	return &BeautifyOptions{Policy: PolicyStrict}
+}
+
+func policyName(policy string) string {
+	if policy == PolicyLenient {
+		return "宽松模式（跳过未找到的代码对）"
+	}
+	return "严格模式（任一代码对未找到即终止）"
+}
+
+// 获取会话中的任务选项
+func sessionOptions(processData map[string]interface{}) *BeautifyOptions {
+	if opts, ok := processData["options"].(*BeautifyOptions); ok {
+		return opts
+	}
+	return defaultBeautifyOptions()
+}
+
+// 获取会话的基础价格，使用共享预设时为预设价格
+func sessionCost(processData map[string]interface{}) float64 {
+	if cost, ok := processData["cost"].(float64); ok {
+		return cost
+	}
+	return pricingRules().Base
+}
+
+// 会话使用的共享预设，未使用或已被修改时为空
+func sessionLibraryPreset(processData map[string]interface{}) string {
+	name, _ := processData["library_preset"].(string)
+	return name
+}
+
+/******************* 美化选项按钮 *******************/
+func beautifyOptionButtons() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("严格模式", "policy_strict"),
+			tgbotapi.NewInlineKeyboardButtonData("宽松模式", "policy_lenient"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔍 预览模式（免费）", "preview_on"),
+			tgbotapi.NewInlineKeyboardButtonData("📦 多文件模式", "multi_on"),
+		),
+	)
+}
+
+/******************* 切换未找到策略 *******************/
+func setBeautifyPolicy(bot *tgbotapi.BotAPI, user *User, chatID int64, policy string) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 当前没有进行中的美化任务，请先点击「自动美化」"))
+		return
+	}
+
+	processData := data.(map[string]interface{})
+	processData["last_activity"] = time.Now()
+	opts := sessionOptions(processData)
+	opts.Policy = policy
+	processData["options"] = opts
+	processingUsers.Store(user.ID, processData)
+
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ 已切换为%s", policyName(policy))))
+}
+
+// 美化任务结果
+type BeautifyResult struct {
+	Records []SwapRecord // 每个文件中每个代码对的处理结果
+	Files   []PatchFile  // 被修改文件的补丁记录
+	Targets []string     // 符合筛选规则的文件
+	Skipped []string     // 不符合筛选规则而跳过的文件
+}
+
+func newBeautifyResult() *BeautifyResult {
+	return &BeautifyResult{
+		Records: make([]SwapRecord, 0),
+		Files:   make([]PatchFile, 0),
+		Targets: make([]string, 0),
+		Skipped: make([]string, 0),
+	}
+}
+
+// 合并嵌套压缩包的处理结果，补丁记录由调用方按层级保存
+func (r *BeautifyResult) merge(nested *BeautifyResult) {
+	if nested == nil {
+		return
+	}
+	r.Records = append(r.Records, nested.Records...)
+	r.Targets = append(r.Targets, nested.Targets...)
+	r.Skipped = append(r.Skipped, nested.Skipped...)
+}
+
+// 是否有代码对被找到
+func anyFound(records []SwapRecord) bool {
+	for _, r := range records {
+		if r.Found {
+			return true
+		}
+	}
+	return false
+}
+
+// 文件没有任何字节被修改时的提示，是否扣费以补丁为准而不是代码对是否找到
+func unchangedNotice(records []SwapRecord) string {
+	if anyFound(records) {
+		return "⚠️ 代码对交换后文件内容没有变化，未扣除积分"
+	}
+	return "⚠️ 所有代码对均未找到，文件未被修改，未扣除积分"
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// 计费流水的操作类型
+const (
+	ChargeReserve = "reserve" // 任务加入队列时冻结积分
+	ChargeCommit  = "commit"  // 结果发送成功后扣除实际价格并解冻
+	ChargeRelease = "release" // 任务失败、取消或重启后任务已不存在时解冻
+)
+
+var (
+	reservationsFile = "reservations.json" // 尚未结算的冻结积分
+	billingLogFile   = "billing.log"       // 计费流水，每行一条 JSON
+)
+
+// 一个任务冻结的积分，结算前从可用积分中扣除
+type Reservation struct {
+	JobID     string    `json:"job_id"`
+	UserID    int64     `json:"user_id"`
+	Amount    float64   `json:"amount"`
+	Free      bool      `json:"free,omitempty"` // 占用当天的免费次数，结算后计入已用
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 计费流水
+type BillingEntry struct {
+	Time    time.Time `json:"time"`
+	UserID  int64     `json:"user_id"`
+	JobID   string    `json:"job_id"`
+	Action  string    `json:"action"`
+	Amount  float64   `json:"amount"`
+	Points  float64   `json:"points"`   // 操作后的积分
+	Reserve float64   `json:"reserved"` // 操作后仍冻结的积分
+}
+
+var reservations = make(map[string]*Reservation) // 任务 ID -> 冻结记录，由 mu 保护
+
+/******************* 可用积分 *******************/
+// 积分减去尚未结算的冻结积分，调用方需持有 mu
+func availablePointsLocked(user *User) float64 {
+	return user.Points - reservedPointsLocked(user.ID)
+}
+
+func reservedPointsLocked(userID int64) float64 {
+	total := 0.0
+	for _, r := range reservations {
+		if r.UserID == userID {
+			total += r.Amount
+		}
+	}
+	return total
+}
+
+func availablePoints(user *User) float64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return availablePointsLocked(user)
+}
+
+func reservedPoints(userID int64) float64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return reservedPointsLocked(userID)
+}
+
+/******************* 冻结积分 *******************/
+// 可用积分或免费次数不足时返回错误，成功后冻结记录立即写入磁盘
+func reservePoints(user *User, jobID string, amount float64, free bool) error {
+	mu.Lock()
+	if free && freeJobsLeftLocked(user.ID) <= 0 {
+		mu.Unlock()
+		return errors.New("今日免费次数已用完，请重新发送文件获取报价")
+	}
+	if available := availablePointsLocked(user); available < amount {
+		mu.Unlock()
+		return fmt.Errorf("积分不足，本次任务需要 %.2f 积分，当前可用 %.2f 积分", amount, available)
+	}
+	reservations[jobID] = &Reservation{JobID: jobID, UserID: user.ID, Amount: amount, Free: free, CreatedAt: time.Now()}
+	entry := billingEntryLocked(user, jobID, ChargeReserve, amount)
+	saveReservationsLocked()
+	mu.Unlock()
+
+	appendBillingLog(entry)
+	return nil
+}
+
+/******************* 结算 *******************/
+// 结果发送成功后扣除实际价格（不超过冻结的积分和现有积分）并解冻，返回扣除的积分
+func commitCharge(user *User, job *BeautifyJob, price float64) float64 {
+	jobID := job.ID
+	// 先在队列中标记已交付：之后任何时刻退出，重启时都不会重新运行和扣费，最多少扣这一次
+	markJobDelivered(job)
+
+	mu.Lock()
+	r, ok := reservations[jobID]
+	if !ok {
+		mu.Unlock()
+		log.Printf("任务 %s 没有冻结积分，跳过扣费", jobID)
+		return 0
+	}
+	if price > r.Amount {
+		price = r.Amount
+	}
+	// 冻结期间管理员可能扣减了积分，不扣成负数
+	if price > user.Points {
+		price = user.Points
+	}
+	if price < 0 {
+		price = 0
+	}
+	user.Points -= price
+	if r.Free {
+		recordFreeUse(user.ID)
+	}
+	delete(reservations, jobID)
+	entry := billingEntryLocked(user, jobID, ChargeCommit, price)
+	mu.Unlock()
+
+	saveData()
+	saveReservations()
+	appendBillingLog(entry)
+	return price
+}
+
+// 解冻任务的积分，任务已结算或没有冻结时不做任何事
+func releaseCharge(jobID string) {
+	mu.Lock()
+	r, ok := reservations[jobID]
+	if !ok {
+		mu.Unlock()
+		return
+	}
+	delete(reservations, jobID)
+	var entry BillingEntry
+	if user, exists := users[r.UserID]; exists {
+		entry = billingEntryLocked(user, jobID, ChargeRelease, r.Amount)
+	} else {
+		entry = BillingEntry{Time: time.Now(), UserID: r.UserID, JobID: jobID, Action: ChargeRelease, Amount: r.Amount}
+	}
+	saveReservationsLocked()
+	mu.Unlock()
+
+	appendBillingLog(entry)
+}
+
+// 结算后用户的可用积分，用于结果消息
+func balanceAfterCommit(user *User, jobID string, price float64) float64 {
+	mu.Lock()
+	defer mu.Unlock()
+	available := availablePointsLocked(user)
+	if r, ok := reservations[jobID]; ok {
+		if price > r.Amount {
+			price = r.Amount
+		}
+		available += r.Amount
+	}
+	return available - price
+}
+
+/******************* 计费流水 *******************/
+func billingEntryLocked(user *User, jobID, action string, amount float64) BillingEntry {
+	return BillingEntry{
+		Time:    time.Now(),
+		UserID:  user.ID,
+		JobID:   jobID,
+		Action:  action,
+		Amount:  amount,
+		Points:  user.Points,
+		Reserve: reservedPointsLocked(user.ID),
+	}
+}
+
+// 追加写入并刷盘
+func appendBillingLog(entry BillingEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("序列化计费流水失败: %v", err)
+		return
+	}
+	f, err := os.OpenFile(billingLogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("打开计费流水失败: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("写入计费流水失败: %v", err)
+		return
+	}
+	if err := f.Sync(); err != nil {
+		log.Printf("保存计费流水失败: %v", err)
+	}
+}
+
+/******************* 加载/保存 冻结记录 *******************/
+func saveReservationsLocked() {
+	data, err := json.MarshalIndent(reservations, "", "  ")
+	if err != nil {
+		log.Printf("序列化冻结积分失败: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(reservationsFile, data, 0644); err != nil {
+		log.Printf("保存冻结积分失败: %v", err)
+	}
+}
+
+func saveReservations() {
+	mu.Lock()
+	defer mu.Unlock()
+	saveReservationsLocked()
+}
+
+func loadReservations() {
+	file, err := ioutil.ReadFile(reservationsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取冻结积分文件失败: %v", err)
+		}
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if err := json.Unmarshal(file, &reservations); err != nil {
+		log.Printf("解析冻结积分文件失败: %v", err)
+		reservations = make(map[string]*Reservation)
+	}
+}
+
+// 启动时解冻队列中已不存在的任务，需在加载队列之后调用
+func releaseOrphanReservations() {
+	jobQueueMu.Lock()
+	queued := make(map[string]bool, len(jobQueue))
+	for _, job := range jobQueue {
+		queued[job.ID] = true
+	}
+	jobQueueMu.Unlock()
+
+	mu.Lock()
+	orphans := make([]string, 0)
+	for id := range reservations {
+		if !queued[id] {
+			orphans = append(orphans, id)
+		}
+	}
+	mu.Unlock()
+
+	for _, id := range orphans {
+		releaseCharge(id)
+	}
+	if len(orphans) > 0 {
+		log.Printf("已解冻 %d 个未完成任务的积分", len(orphans))
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 缓存的美化结果
+type CacheEntry struct {
+	Key          string          `json:"key"`
+	OutputSHA256 string          `json:"output_sha256"`
+	Size         int64           `json:"size"`
+	Result       *BeautifyResult `json:"result"`
+	Hits         int             `json:"hits"`
+	CreatedAt    time.Time       `json:"created_at"`
+	LastUsed     time.Time       `json:"last_used"`
+}
+
+// 缓存索引，与结果文件一起保存在缓存目录中
+type resultCacheIndex struct {
+	Charge  bool                   `json:"charge"` // 命中缓存时是否仍然扣积分
+	Entries map[string]*CacheEntry `json:"entries"`
+}
+
+var (
+	resultCacheDir      = "cache"
+	resultCacheMaxBytes = int64(500 * 1024 * 1024) // 缓存总大小上限 500MB
+	resultCacheMaxAge   = 7 * 24 * time.Hour       // 缓存最长保留时间
+	resultCache         = &resultCacheIndex{Charge: true, Entries: make(map[string]*CacheEntry)}
+	resultCacheMu       sync.Mutex
+)
+
+/******************* 缓存键 *******************/
+// 由输入文件哈希、文件格式、规范化后的代码对和任务选项计算，代码对名称不影响结果因此不参与计算
+func resultCacheKey(inputHash, format string, codes []CodePair, opts *BeautifyOptions) string {
+	var sb strings.Builder
+	sb.WriteString(inputHash)
+	sb.WriteString("|format=" + format)
+	sb.WriteString("|policy=" + opts.Policy)
+	sb.WriteString("|semantics=" + opts.Semantics)
+	sb.WriteString("|include=" + strings.Join(opts.Filter.includePatterns(), ","))
+	sb.WriteString("|exclude=" + strings.Join(opts.Filter.Exclude, ","))
+	for _, pair := range codes {
+		sb.WriteString(fmt.Sprintf("|%s>%s@%s", pair.HexA, pair.HexB, pair.Strategy))
+	}
+	sum := sha256.Sum256([]byte(sb.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func resultCachePath(key string) string {
+	return filepath.Join(resultCacheDir, key+".bin")
+}
+
+/******************* 读取/写入缓存 *******************/
+func lookupResultCache(key string) (*CacheEntry, bool) {
+	resultCacheMu.Lock()
+	defer resultCacheMu.Unlock()
+
+	entry, ok := resultCache.Entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Since(entry.CreatedAt) > resultCacheMaxAge {
+		removeCacheEntry(entry)
+		return nil, false
+	}
+	if _, err := os.Stat(resultCachePath(key)); err != nil {
+		delete(resultCache.Entries, key)
+		return nil, false
+	}
+
+	entry.Hits++
+	entry.LastUsed = time.Now()
+	copied := *entry
+	return &copied, true
+}
+
+func storeResultCache(key string, outputPath string, result *BeautifyResult) {
+	info, err := os.Stat(outputPath)
+	if err != nil || info.Size() > resultCacheMaxBytes {
+		return
+	}
+	outputHash, err := sha256File(outputPath)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(resultCacheDir, 0755); err != nil {
+		log.Printf("创建缓存目录失败: %v", err)
+		return
+	}
+	if err := copyFile(outputPath, resultCachePath(key)); err != nil {
+		log.Printf("写入结果缓存失败: %v", err)
+		return
+	}
+
+	now := time.Now()
+	resultCacheMu.Lock()
+	resultCache.Entries[key] = &CacheEntry{
+		Key:          key,
+		OutputSHA256: outputHash,
+		Size:         info.Size(),
+		Result:       result,
+		CreatedAt:    now,
+		LastUsed:     now,
+	}
+	resultCacheMu.Unlock()
+
+	evictResultCache()
+}
+
+// 移除结果文件已不存在的索引
+func dropCacheEntry(key string) {
+	resultCacheMu.Lock()
+	delete(resultCache.Entries, key)
+	resultCacheMu.Unlock()
+	saveResultCache()
+}
+
+// 需持有 resultCacheMu
+func removeCacheEntry(entry *CacheEntry) {
+	os.Remove(resultCachePath(entry.Key))
+	delete(resultCache.Entries, entry.Key)
+}
+
+/******************* 缓存淘汰 *******************/
+// 先删除过期条目，再按最近使用时间淘汰直到总大小不超过上限
+func evictResultCache() {
+	resultCacheMu.Lock()
+	entries := make([]*CacheEntry, 0, len(resultCache.Entries))
+	total := int64(0)
+	for _, entry := range resultCache.Entries {
+		if time.Since(entry.CreatedAt) > resultCacheMaxAge {
+			removeCacheEntry(entry)
+			continue
+		}
+		entries = append(entries, entry)
+		total += entry.Size
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastUsed.Before(entries[j].LastUsed) })
+	for _, entry := range entries {
+		if total <= resultCacheMaxBytes {
+			break
+		}
+		total -= entry.Size
+		removeCacheEntry(entry)
+	}
+	resultCacheMu.Unlock()
+
+	saveResultCache()
+}
+
+/******************* 发送缓存结果 *******************/
+// 命中缓存时直接发送结果，返回实际扣除的积分和保存的结果文件
+// 缓存文件在查找后已被淘汰或删除时移除索引并返回 os.ErrNotExist，由调用方改为正常处理
+func deliverCachedResult(bot *tgbotapi.BotAPI, job *BeautifyJob, user *User, entry *CacheEntry, inputPath, fileName, inputHash string) (float64, *JobOutput, error) {
+	chatID := job.ChatID
+	path := resultCachePath(entry.Key)
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			dropCacheEntry(entry.Key)
+		}
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	resultCacheMu.Lock()
+	charge := resultCache.Charge
+	resultCacheMu.Unlock()
+
+	price := 0.0
+	if charge {
+		price = job.Cost
+	}
+
+	newName := modifiedFileName(fileName)
+	msg := tgbotapi.NewDocument(chatID, tgbotapi.FileReader{Name: newName, Reader: f})
+	msg.Caption = fmt.Sprintf("⚡ 命中结果缓存，美化完成！消耗%.2f积分，剩余积分: %.2f", price, balanceAfterCommit(user, job.ID, price))
+	sent, err := bot.Send(msg)
+	if err != nil {
+		log.Printf("发送缓存文件失败: %v", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 发送文件失败，积分已退回，请稍后重试"))
+		return 0, nil, err
+	}
+	// 不扣积分时解冻，免费次数也一并退回
+	if charge {
+		price = commitCharge(user, job, price)
+	} else {
+		releaseCharge(job.ID)
+	}
+	output := saveJobOutput(job.ID, path, newName, sentFileID(sent))
+
+	manifest := &PatchManifest{
+		Version:      patchManifestVersion,
+		InputName:    fileName,
+		InputSHA256:  inputHash,
+		OutputName:   newName,
+		OutputSHA256: entry.OutputSHA256,
+		CreatedAt:    time.Now(),
+		Files:        entry.Result.Files,
+	}
+	if archiveFormat(fileName) != "" {
+		manifest.Archive = archivePatchFor(inputPath, path)
+	}
+	sendSwapReport(bot, chatID, entry.Result)
+	sendPatchManifest(bot, chatID, manifest)
+	saveResultCache()
+	return price, output, nil
+}
+
+/******************* 管理员缓存命令 *******************/
+// /cache stats | /cache charge on|off | /cache clear
+func handleCacheCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		args = []string{"stats"}
+	}
+
+	switch args[0] {
+	case "stats":
+		resultCacheMu.Lock()
+		total, hits := int64(0), 0
+		for _, entry := range resultCache.Entries {
+			total += entry.Size
+			hits += entry.Hits
+		}
+		text := fmt.Sprintf("🗄 结果缓存：%d 个结果，共 %.2f MB（上限 %.0f MB），累计命中 %d 次\n最长保留 %s，命中缓存时%s",
+			len(resultCache.Entries), float64(total)/1024/1024, float64(resultCacheMaxBytes)/1024/1024, hits,
+			resultCacheMaxAge, map[bool]string{true: "照常扣积分", false: "不扣积分"}[resultCache.Charge])
+		resultCacheMu.Unlock()
+		bot.Send(tgbotapi.NewMessage(chatID, text))
+
+	case "charge":
+		if len(args) < 2 || (args[1] != "on" && args[1] != "off") {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ 用法：/cache charge on|off"))
+			return
+		}
+		resultCacheMu.Lock()
+		resultCache.Charge = args[1] == "on"
+		resultCacheMu.Unlock()
+		saveResultCache()
+		if args[1] == "on" {
+			bot.Send(tgbotapi.NewMessage(chatID, "✅ 命中缓存时将照常扣积分"))
+		} else {
+			bot.Send(tgbotapi.NewMessage(chatID, "✅ 命中缓存时将不再扣积分"))
+		}
+
+	case "clear":
+		resultCacheMu.Lock()
+		count := len(resultCache.Entries)
+		for _, entry := range resultCache.Entries {
+			removeCacheEntry(entry)
+		}
+		resultCacheMu.Unlock()
+		saveResultCache()
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ 已清空 %d 个缓存结果", count)))
+
+	default:
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 用法：/cache stats | /cache charge on|off | /cache clear"))
+	}
+}
+
+/******************* 加载/保存 缓存索引 *******************/
+func resultCacheIndexPath() string {
+	return filepath.Join(resultCacheDir, "index.json")
+}
+
+func loadResultCache() {
+	file, err := ioutil.ReadFile(resultCacheIndexPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取缓存索引失败: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(file, resultCache); err != nil {
+		log.Printf("解析缓存索引失败: %v", err)
+	}
+	if resultCache.Entries == nil {
+		resultCache.Entries = make(map[string]*CacheEntry)
+	}
+}
+
+func saveResultCache() {
+	resultCacheMu.Lock()
+	data, err := json.MarshalIndent(resultCache, "", "  ")
+	resultCacheMu.Unlock()
+	if err != nil {
+		log.Printf("序列化缓存索引失败: %v", err)
+		return
+	}
+	if err := os.MkdirAll(resultCacheDir, 0755); err != nil {
+		log.Printf("创建缓存目录失败: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(resultCacheIndexPath(), data, 0644); err != nil {
+		log.Printf("保存缓存索引失败: %v", err)
+	}
+}
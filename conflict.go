@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 多个代码对之间的交换方式
+const (
+	SemanticsSequential   = "sequential"   // 依次交换：每个代码对作用于上一个代码对修改后的内容（默认）
+	SemanticsSimultaneous = "simultaneous" // 同时交换：所有代码对都在原始内容上查找，互不影响
+)
+
+func semanticsName(semantics string) string {
+	if semantics == SemanticsSimultaneous {
+		return "同时交换（所有代码对都在原始文件上查找，同一位置以靠前的代码对为准）"
+	}
+	return "依次交换（每个代码对作用于上一个代码对修改后的内容）"
+}
+
+/******************* 代码对冲突检查 *******************/
+// 检查无效、重复、链式/成环和字节序列相互包含的代码对，返回给用户的提示，没有问题时返回空切片
+func analyzePairs(codes []CodePair) []string {
+	warnings := make([]string, 0)
+	names := make(map[string]string) // 搜索序列 -> 首次出现时用户输入的代码
+	for _, pair := range codes {
+		if _, ok := names[pair.HexA]; !ok {
+			names[pair.HexA] = pair.Original
+		}
+		if _, ok := names[pair.HexB]; !ok {
+			names[pair.HexB] = pair.New
+		}
+	}
+
+	// 无效与重复的代码对不参与链式检查
+	counted := make([]bool, len(codes))
+	seen := make(map[string]int)
+	for i, pair := range codes {
+		if pair.HexA == pair.HexB {
+			warnings = append(warnings, fmt.Sprintf("无效：#%d %s 两个代码相同，交换不会产生任何变化", i+1, pair))
+			continue
+		}
+		key := pairKey(pair)
+		if j, ok := seen[key]; ok {
+			warnings = append(warnings, fmt.Sprintf("重复：#%d 与 #%d 交换相同的两个代码，依次交换时会互相抵消", j+1, i+1))
+			continue
+		}
+		seen[key] = i
+		counted[i] = true
+	}
+
+	// 以搜索序列为节点、代码对为边，边数不少于两条的连通分量即为链式交换，边数不少于节点数时成环
+	parent := make(map[string]string)
+	var root func(string) string
+	root = func(x string) string {
+		if parent[x] == "" || parent[x] == x {
+			return x
+		}
+		parent[x] = root(parent[x])
+		return parent[x]
+	}
+	for i, pair := range codes {
+		if counted[i] {
+			if a, b := root(pair.HexA), root(pair.HexB); a != b {
+				parent[a] = b
+			}
+		}
+	}
+	groups := make(map[string][]int)
+	order := make([]string, 0)
+	for i, pair := range codes {
+		if !counted[i] {
+			continue
+		}
+		r := root(pair.HexA)
+		if _, ok := groups[r]; !ok {
+			order = append(order, r)
+		}
+		groups[r] = append(groups[r], i)
+	}
+	for _, r := range order {
+		members := groups[r]
+		if len(members) < 2 {
+			continue
+		}
+		nodes := make(map[string]bool)
+		refs := make([]string, 0, len(members))
+		for _, i := range members {
+			nodes[codes[i].HexA], nodes[codes[i].HexB] = true, true
+			refs = append(refs, fmt.Sprintf("#%d %s↔%s", i+1, codes[i].Original, codes[i].New))
+		}
+		if len(members) >= len(nodes) {
+			warnings = append(warnings, fmt.Sprintf("成环：%s 首尾相连，依次交换的结果取决于顺序", strings.Join(refs, "、")))
+		} else {
+			warnings = append(warnings, fmt.Sprintf("链式：%s 共用代码，依次交换时前一个代码对换入的代码会被后一个再次换走", strings.Join(refs, "、")))
+		}
+	}
+
+	// 不同代码的字节序列相互包含时，修改其中一个会改变另一个的匹配位置
+	seqs := make([]string, 0, len(names))
+	for _, pair := range codes {
+		for _, h := range []string{pair.HexA, pair.HexB} {
+			if !containsString(seqs, h) {
+				seqs = append(seqs, h)
+			}
+		}
+	}
+	for _, outer := range seqs {
+		for _, inner := range seqs {
+			if outer != inner && hexContains(outer, inner) {
+				warnings = append(warnings, fmt.Sprintf("重叠：%s 的字节序列包含 %s（%s ⊃ %s），修改其中一个会影响另一个的匹配", names[outer], names[inner], outer, inner))
+			}
+		}
+	}
+	return warnings
+}
+
+// 与两个代码的先后无关的代码对标识
+func pairKey(pair CodePair) string {
+	if pair.HexA < pair.HexB {
+		return pair.HexA + "|" + pair.HexB
+	}
+	return pair.HexB + "|" + pair.HexA
+}
+
+// 按字节（两个十六进制字符）对齐判断 outer 是否包含 inner
+func hexContains(outer, inner string) bool {
+	for i := 0; i+len(inner) <= len(outer); i += 2 {
+		if outer[i:i+len(inner)] == inner {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 代码对有冲突时提示用户并提供交换方式按钮
+func sendPairAnalysis(bot *tgbotapi.BotAPI, chatID int64, codes []CodePair, opts *BeautifyOptions) {
+	warnings := analyzePairs(codes)
+	if len(warnings) == 0 {
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("⚠️ 代码对检查发现 %d 个问题：\n", len(warnings)))
+	for _, w := range warnings {
+		sb.WriteString("• " + w + "\n")
+	}
+	sb.WriteString("\n当前为" + semanticsName(opts.Semantics) + "，可点击下方按钮切换。")
+
+	msg := tgbotapi.NewMessage(chatID, sb.String())
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("依次交换", "semantics_sequential"),
+			tgbotapi.NewInlineKeyboardButtonData("同时交换", "semantics_simultaneous"),
+		),
+	)
+	bot.Send(msg)
+}
+
+/******************* 切换交换方式 *******************/
+func setBeautifySemantics(bot *tgbotapi.BotAPI, user *User, chatID int64, semantics string) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 当前没有进行中的美化任务，请先点击「自动美化」"))
+		return
+	}
+
+	processData := data.(map[string]interface{})
+	processData["last_activity"] = time.Now()
+	opts := sessionOptions(processData)
+	opts.Semantics = semantics
+	processData["options"] = opts
+	processingUsers.Store(user.ID, processData)
+
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ 已切换为%s", semanticsName(semantics))))
+}
+
+/******************* 同时交换的写入位置 *******************/
+// 同时交换时记录已被靠前的代码对写入的字节，同一位置只写入一次
+type swapClaims map[int]bool
+
+// 去掉与已写入字节重叠的偏移，返回保留的偏移和被去掉的个数
+func (c swapClaims) filter(offsets []int, size int) ([]int, int) {
+	kept := make([]int, 0, len(offsets))
+	for _, offset := range offsets {
+		overlapped := false
+		for i := offset; i < offset+size; i++ {
+			if c[i] {
+				overlapped = true
+				break
+			}
+		}
+		if !overlapped {
+			kept = append(kept, offset)
+		}
+	}
+	return kept, len(offsets) - len(kept)
+}
+
+func (c swapClaims) claim(offsets []int, size int) {
+	for _, offset := range offsets {
+		for i := offset; i < offset+size; i++ {
+			c[i] = true
+		}
+	}
+}
+
+// 同时交换：按原始内容的匹配位置分配写入位置，与前面代码对重叠的位置留给前面的代码对，
+// 只有代码在原始内容中未找到时才返回原因；链式代码对（A↔B、B↔C）中后者只写入未被占用的位置
+func claimPairOffsets(claims swapClaims, pair CodePair, offsetsA, offsetsB []int) ([]int, []int, string) {
+	if len(offsetsA) == 0 || len(offsetsB) == 0 {
+		return offsetsA, offsetsB, missingReason(pair, offsetsA, offsetsB)
+	}
+	size := len(pair.HexA) / 2
+	keptA, _ := claims.filter(offsetsA, size)
+	keptB, _ := claims.filter(offsetsB, size)
+	claims.claim(keptA, size)
+	claims.claim(keptB, size)
+	return keptA, keptB, ""
+}
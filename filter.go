@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 未设置包含规则时默认处理的文件
+var defaultIncludePatterns = []string{"*.dat"}
+
+// 报告中每类文件最多列出的数量
+const fileListLimit = 20
+
+// 目标文件筛选规则，模式不含 / 时匹配文件名，否则匹配包内完整路径
+// 通配符 * 匹配除 / 以外的任意字符，? 匹配除 / 以外的单个字符，** 匹配任意层级的目录
+type FileFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+var globCache sync.Map // 模式 -> *regexp.Regexp
+
+func (f FileFilter) IsZero() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+func (f FileFilter) includePatterns() []string {
+	if len(f.Include) == 0 {
+		return defaultIncludePatterns
+	}
+	return f.Include
+}
+
+// 文件是否需要处理
+func (f FileFilter) Match(relPath string) bool {
+	return matchAnyGlob(f.includePatterns(), relPath) && !f.Excluded(relPath)
+}
+
+// 文件是否被排除规则命中，排除规则同样作用于嵌套压缩包
+func (f FileFilter) Excluded(relPath string) bool {
+	return matchAnyGlob(f.Exclude, relPath)
+}
+
+func (f FileFilter) String() string {
+	text := "包含 " + strings.Join(f.includePatterns(), " ")
+	if len(f.Exclude) > 0 {
+		text += "；排除 " + strings.Join(f.Exclude, " ")
+	}
+	return text
+}
+
+/******************* 通配符匹配 *******************/
+func matchAnyGlob(patterns []string, relPath string) bool {
+	relPath = strings.ReplaceAll(relPath, "\\", "/")
+	for _, pattern := range patterns {
+		target := relPath
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(relPath)
+		}
+		if re, err := compileGlob(pattern); err == nil && re.MatchString(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// 将通配符转换为正则表达式（不区分大小写）
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	if re, ok := globCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("(?i)^")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				i++
+				if i+1 < len(runes) && runes[i+1] == '/' {
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+	sb.WriteString("$")
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, err
+	}
+	globCache.Store(pattern, re)
+	return re, nil
+}
+
+// 解析空格或逗号分隔的模式列表
+func parseGlobList(text string) ([]string, error) {
+	fields := strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '，' || r == ' ' || r == '\n' })
+	patterns := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if strings.HasPrefix(field, "/") || strings.Contains(field, "..") {
+			return nil, fmt.Errorf("无效的模式: %s", field)
+		}
+		if _, err := compileGlob(field); err != nil {
+			return nil, fmt.Errorf("无效的模式: %s", field)
+		}
+		patterns = append(patterns, field)
+	}
+	return patterns, nil
+}
+
+// 获取会话中的筛选规则，没有会话时为默认规则
+func sessionFilter(userID int64) FileFilter {
+	data, ok := processingUsers.Load(userID)
+	if !ok {
+		return FileFilter{}
+	}
+	return sessionOptions(data.(map[string]interface{})).Filter
+}
+
+/******************* 设置筛选规则 *******************/
+// /filter                        查看当前规则
+// /filter include <模式...>      设置包含规则
+// /filter exclude <模式...>      设置排除规则
+// /filter reset                  恢复默认规则
+func handleFilterCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message, user *User) {
+	chatID := message.Chat.ID
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 当前没有进行中的美化任务，请先点击「自动美化」"))
+		return
+	}
+
+	processData := data.(map[string]interface{})
+	processData["last_activity"] = time.Now()
+	opts := sessionOptions(processData)
+
+	action, rest, _ := strings.Cut(strings.TrimSpace(message.CommandArguments()), " ")
+	switch strings.ToLower(action) {
+	case "":
+		bot.Send(tgbotapi.NewMessage(chatID, "📂 当前目标文件规则："+opts.Filter.String()+
+			"\n\n用法：\n/filter include <模式...>\n/filter exclude <模式...>\n/filter reset\n模式支持 * ? **，不含 / 时匹配文件名，例如 *.dat *.bin config/**"))
+		return
+
+	case "include", "exclude":
+		patterns, err := parseGlobList(rest)
+		if err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ "+err.Error()))
+			return
+		}
+		if action == "include" {
+			opts.Filter.Include = patterns
+		} else {
+			opts.Filter.Exclude = patterns
+		}
+
+	case "reset":
+		opts.Filter = FileFilter{}
+
+	default:
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 用法：/filter include <模式...> | /filter exclude <模式...> | /filter reset"))
+		return
+	}
+
+	processData["options"] = opts
+	clearLibraryPricing(bot, chatID, processData)
+	processingUsers.Store(user.ID, processData)
+	bot.Send(tgbotapi.NewMessage(chatID, "✅ 目标文件规则："+opts.Filter.String()))
+}
+
+/******************* 文件筛选报告 *******************/
+func formatFileSelection(result *BeautifyResult) string {
+	if len(result.Targets) == 0 && len(result.Skipped) == 0 {
+		return ""
+	}
+
+	patched := make(map[string]bool)
+	for _, r := range result.Records {
+		if r.Found {
+			patched[r.File] = true
+		}
+	}
+	modified, unchanged := make([]string, 0), make([]string, 0)
+	for _, file := range result.Targets {
+		if patched[file] {
+			modified = append(modified, file)
+		} else {
+			unchanged = append(unchanged, file)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📂 目标文件：匹配 %d 个，已修改 %d 个，跳过 %d 个\n", len(result.Targets), len(modified), len(result.Skipped)))
+	writeFileList(&sb, "已修改", modified)
+	writeFileList(&sb, "未修改", unchanged)
+	writeFileList(&sb, "已跳过", result.Skipped)
+	return sb.String()
+}
+
+func writeFileList(sb *strings.Builder, title string, files []string) {
+	if len(files) == 0 {
+		return
+	}
+	shown := files
+	if len(shown) > fileListLimit {
+		shown = shown[:fileListLimit]
+	}
+	sb.WriteString(fmt.Sprintf("%s：%s", title, strings.Join(shown, ", ")))
+	if len(files) > len(shown) {
+		sb.WriteString(fmt.Sprintf(" 等（共 %d 个）", len(files)))
+	}
+	sb.WriteString("\n")
+}
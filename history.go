@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// 美化任务记录
+type JobRecord struct {
+	ID        string     `json:"id,omitempty"` // 队列中的任务 ID
+	UserID    int64      `json:"user_id"`
+	FileName  string     `json:"file_name"`
+	PairCount int        `json:"pair_count"`
+	Success   bool       `json:"success"`
+	Error     string     `json:"error,omitempty"`
+	Cost      float64    `json:"cost"`
+	CreatedAt time.Time  `json:"created_at"`
+	Output    *JobOutput `json:"output,omitempty"` // 结果文件，保留期内可通过 /jobs 重新下载
+}
+
+const maxJobRecords = 500 // 最多保留的任务记录条数
+
+var (
+	jobRecords   = make([]*JobRecord, 0)
+	checkInStats = make(map[string]int) // 每日签到次数，键为 2006-01-02
+	recordsMu    sync.Mutex
+)
+
+/******************* 记录美化任务 *******************/
+func recordJob(job *BeautifyJob, fileName string, cost float64, output *JobOutput, jobErr error) {
+	record := &JobRecord{
+		ID:        job.ID,
+		UserID:    job.UserID,
+		FileName:  fileName,
+		PairCount: len(job.Codes),
+		Success:   jobErr == nil,
+		Cost:      cost,
+		CreatedAt: time.Now(),
+		Output:    output,
+	}
+	if jobErr != nil {
+		record.Error = jobErr.Error()
+	}
+
+	recordsMu.Lock()
+	jobRecords = append(jobRecords, record)
+	if len(jobRecords) > maxJobRecords {
+		// 被淘汰的记录一并删除结果文件
+		for _, old := range jobRecords[:len(jobRecords)-maxJobRecords] {
+			if old.Output != nil && old.Output.Path != "" {
+				os.Remove(old.Output.Path)
+			}
+		}
+		jobRecords = jobRecords[len(jobRecords)-maxJobRecords:]
+	}
+	recordsMu.Unlock()
+	saveJobs()
+}
+
+// 按时间倒序返回最近的任务记录
+func recentJobs(limit int) []*JobRecord {
+	recordsMu.Lock()
+	defer recordsMu.Unlock()
+
+	result := make([]*JobRecord, 0, limit)
+	for i := len(jobRecords) - 1; i >= 0 && len(result) < limit; i-- {
+		result = append(result, jobRecords[i])
+	}
+	return result
+}
+
+/******************* 记录签到统计 *******************/
+func recordCheckIn(t time.Time) {
+	recordsMu.Lock()
+	checkInStats[t.Format("2006-01-02")]++
+	recordsMu.Unlock()
+	saveStats()
+}
+
+/******************* 加载/保存 任务记录 *******************/
+func loadJobs() {
+	file, err := ioutil.ReadFile(jobsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取任务记录文件失败: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(file, &jobRecords); err != nil {
+		log.Printf("解析任务记录文件失败: %v", err)
+	}
+}
+
+func saveJobs() {
+	recordsMu.Lock()
+	data, err := json.MarshalIndent(jobRecords, "", "  ")
+	recordsMu.Unlock()
+	if err != nil {
+		log.Printf("序列化任务记录失败: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(jobsFile, data, 0644); err != nil {
+		log.Printf("保存任务记录失败: %v", err)
+	}
+}
+
+/******************* 加载/保存 统计数据 *******************/
+func loadStats() {
+	file, err := ioutil.ReadFile(statsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取统计文件失败: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(file, &checkInStats); err != nil {
+		log.Printf("解析统计文件失败: %v", err)
+	}
+}
+
+func saveStats() {
+	recordsMu.Lock()
+	data, err := json.MarshalIndent(checkInStats, "", "  ")
+	recordsMu.Unlock()
+	if err != nil {
+		log.Printf("序列化统计数据失败: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(statsFile, data, 0644); err != nil {
+		log.Printf("保存统计数据失败: %v", err)
+	}
+}
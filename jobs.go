@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var (
+	jobResultDir       = "results"          // 保存任务结果文件的目录
+	jobResultRetention = 3 * 24 * time.Hour // 结果文件的保留时间，超出后不能重新下载
+	jobListLimit       = 10                 // /jobs 列出的任务数
+)
+
+// 任务结果文件：优先用 Telegram 的 file_id 重新发送，失效时上传本地保存的副本
+type JobOutput struct {
+	Name   string `json:"name"`
+	FileID string `json:"file_id,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+/******************* 保存结果文件 *******************/
+// 把发送给用户的结果复制到结果目录，fileID 为发送成功后 Telegram 返回的文件 ID
+func saveJobOutput(jobID, src, name, fileID string) *JobOutput {
+	output := &JobOutput{Name: name, FileID: fileID}
+	if err := os.MkdirAll(jobResultDir, 0755); err != nil {
+		log.Printf("创建结果目录失败: %v", err)
+		return output
+	}
+	path := filepath.Join(jobResultDir, jobID+filepath.Ext(name))
+	if err := copyFile(src, path); err != nil {
+		log.Printf("保存结果文件失败: %v", err)
+		return output
+	}
+	output.Path = path
+	return output
+}
+
+// 发送消息返回的文档 file_id
+func sentFileID(sent tgbotapi.Message) string {
+	if sent.Document != nil {
+		return sent.Document.FileID
+	}
+	return ""
+}
+
+// 删除超过保留时间的结果文件
+func evictJobResults() {
+	recordsMu.Lock()
+	expired := make([]string, 0)
+	for _, record := range jobRecords {
+		if record.Output != nil && time.Since(record.CreatedAt) > jobResultRetention {
+			if record.Output.Path != "" {
+				expired = append(expired, record.Output.Path)
+			}
+			record.Output = nil
+		}
+	}
+	recordsMu.Unlock()
+
+	for _, path := range expired {
+		os.Remove(path)
+	}
+	if len(expired) > 0 {
+		log.Printf("已清理 %d 个过期的结果文件", len(expired))
+		saveJobs()
+	}
+}
+
+/******************* 查看任务记录 *******************/
+// /jobs：列出最近的任务，保留期内的结果可点击按钮重新下载
+func handleJobsCommand(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	records := userJobs(user.ID, jobListLimit)
+	if len(records) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "📭 还没有美化任务记录"))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🗂 最近 %d 个美化任务：\n", len(records)))
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	row := make([]tgbotapi.InlineKeyboardButton, 0)
+	for i, record := range records {
+		status := "✅"
+		if !record.Success {
+			status = "❌"
+		}
+		sb.WriteString(fmt.Sprintf("\n%d. %s %s\n   %s · %d个代码对 · 消耗%.2f积分\n",
+			i+1, status, record.FileName, record.CreatedAt.Format("01-02 15:04"), record.PairCount, record.Cost))
+		if !record.Success && record.Error != "" {
+			sb.WriteString("   " + record.Error + "\n")
+		}
+
+		if jobOutputAvailable(record) {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("📥 %d", i+1), "job_get:"+record.ID))
+			if len(row) == 5 {
+				rows = append(rows, row)
+				row = make([]tgbotapi.InlineKeyboardButton, 0)
+			}
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	if len(rows) > 0 {
+		sb.WriteString(fmt.Sprintf("\n点击按钮重新下载结果（保留 %d 小时）", int(jobResultRetention.Hours())))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, sb.String())
+	if len(rows) > 0 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+	bot.Send(msg)
+}
+
+// 按时间倒序返回用户最近的任务记录
+func userJobs(userID int64, limit int) []*JobRecord {
+	recordsMu.Lock()
+	defer recordsMu.Unlock()
+
+	result := make([]*JobRecord, 0, limit)
+	for i := len(jobRecords) - 1; i >= 0 && len(result) < limit; i-- {
+		if jobRecords[i].UserID == userID {
+			result = append(result, jobRecords[i])
+		}
+	}
+	return result
+}
+
+func jobOutputAvailable(record *JobRecord) bool {
+	return record.ID != "" && record.Output != nil && time.Since(record.CreatedAt) <= jobResultRetention
+}
+
+/******************* 重新发送结果 *******************/
+func resendJobOutput(bot *tgbotapi.BotAPI, chatID int64, user *User, id string) {
+	recordsMu.Lock()
+	var output JobOutput
+	found, available := false, false
+	for _, record := range jobRecords {
+		if record.ID == id && record.UserID == user.ID {
+			found, available = true, jobOutputAvailable(record)
+			if available {
+				output = *record.Output
+			}
+			break
+		}
+	}
+	recordsMu.Unlock()
+
+	if !found {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 任务不存在"))
+		return
+	}
+	if !available {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 结果文件已超过保留时间，请重新提交任务"))
+		return
+	}
+
+	caption := "📥 重新发送的美化结果"
+	if output.FileID != "" {
+		msg := tgbotapi.NewDocument(chatID, tgbotapi.FileID(output.FileID))
+		msg.Caption = caption
+		if _, err := bot.Send(msg); err == nil {
+			return
+		}
+	}
+
+	f, err := os.Open(output.Path)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 结果文件已不存在，请重新提交任务"))
+		return
+	}
+	defer f.Close()
+
+	msg := tgbotapi.NewDocument(chatID, tgbotapi.FileReader{Name: output.Name, Reader: f})
+	msg.Caption = caption
+	sent, err := bot.Send(msg)
+	if err != nil {
+		log.Printf("重新发送结果失败: %v", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 发送文件失败，请联系管理员"))
+		return
+	}
+
+	// 记下新的 file_id，下次直接复用
+	if fileID := sentFileID(sent); fileID != "" {
+		recordsMu.Lock()
+		for _, record := range jobRecords {
+			if record.ID == id && record.Output != nil {
+				record.Output.FileID = fileID
+			}
+		}
+		recordsMu.Unlock()
+		saveJobs()
+	}
+}
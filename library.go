@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 管理员发布的共享预设
+type LibraryPreset struct {
+	Name        string     `json:"name"`
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	Cost        float64    `json:"cost"` // 使用该预设美化一次消耗的积分
+	Pairs       []CodePair `json:"pairs"`
+	Filter      FileFilter `json:"filter"` // 目标文件规则，为空时沿用会话中的规则
+	UsageCount  int        `json:"usage_count"`
+	PublishedBy int64      `json:"published_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+const libraryPageSize = 5
+
+var (
+	libraryFile = "library.json"
+	library     = make(map[string]*LibraryPreset)
+	libraryMu   sync.Mutex
+)
+
+/******************* 管理员发布/管理共享预设 *******************/
+// /libpreset publish <名称> <积分> [描述]   名称后换行附上代码对，或使用当前会话中的代码对；同名再次发布时版本号加一
+// /libpreset delete <名称>
+// /libpreset stats
+func handleLibraryAdminCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	firstLine, body, _ := strings.Cut(message.CommandArguments(), "\n")
+	args := strings.Fields(firstLine)
+
+	usage := "用法：\n/libpreset publish <名称> <积分> [描述]（换行后附上代码对）\n/libpreset delete <名称>\n/libpreset stats"
+	if len(args) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, usage))
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "publish":
+		if len(args) < 3 {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ 参数不足\n"+usage))
+			return
+		}
+		cost, err := strconv.ParseFloat(args[2], 64)
+		if err != nil || cost < 0 {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ 无效的积分值"))
+			return
+		}
+		description := strings.TrimSpace(strings.Join(args[3:], " "))
+		publishLibraryPreset(bot, message, args[1], cost, description, body)
+
+	case "delete":
+		if len(args) < 2 {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ 请指定预设名称"))
+			return
+		}
+		libraryMu.Lock()
+		_, exists := library[args[1]]
+		delete(library, args[1])
+		libraryMu.Unlock()
+		if !exists {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ 共享预设不存在"))
+			return
+		}
+		saveLibrary()
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ 已删除共享预设「%s」", args[1])))
+
+	case "stats":
+		list := libraryList()
+		sort.SliceStable(list, func(i, j int) bool { return list[i].UsageCount > list[j].UsageCount })
+		var sb strings.Builder
+		sb.WriteString("📊 共享预设使用次数：\n")
+		for _, p := range list {
+			sb.WriteString(fmt.Sprintf("▫️ %s v%d - %d次（%.2f积分/次）\n", p.Name, p.Version, p.UsageCount, p.Cost))
+		}
+		if len(list) == 0 {
+			sb.WriteString("暂无共享预设")
+		}
+		bot.Send(tgbotapi.NewMessage(chatID, sb.String()))
+
+	default:
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 未知的操作\n"+usage))
+	}
+}
+
+func publishLibraryPreset(bot *tgbotapi.BotAPI, message *tgbotapi.Message, name string, cost float64, description, body string) {
+	chatID := message.Chat.ID
+	if len(name) > maxPresetNameLen {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ 预设名称过长（最多%d字节，约%d个汉字）", maxPresetNameLen, maxPresetNameLen/3)))
+		return
+	}
+
+	var pairs []CodePair
+	report := ""
+	if strings.TrimSpace(body) != "" {
+		var issues []PairIssue
+		pairs, issues = parsePairs(body)
+		report = formatParseReport(pairs, issues) + "\n\n"
+	} else if data, ok := processingUsers.Load(message.From.ID); ok {
+		pairs, _ = data.(map[string]interface{})["codes"].([]CodePair)
+	}
+	if len(pairs) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, report+"❌ 没有可发布的代码对"))
+		return
+	}
+
+	now := time.Now()
+	libraryMu.Lock()
+	preset, exists := library[name]
+	if !exists {
+		preset = &LibraryPreset{Name: name, CreatedAt: now}
+		library[name] = preset
+	}
+	preset.Version++
+	preset.Description = description
+	preset.Cost = cost
+	preset.Pairs = pairs
+	preset.Filter = sessionFilter(message.From.ID)
+	preset.PublishedBy = message.From.ID
+	preset.UpdatedAt = now
+	version := preset.Version
+	libraryMu.Unlock()
+	saveLibrary()
+
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("%s✅ 共享预设「%s」已发布为 v%d，共%d个代码对，每次使用消耗%.2f积分",
+		report, name, version, len(pairs), cost)))
+}
+
+/******************* 浏览共享预设 *******************/
+func libraryList() []*LibraryPreset {
+	libraryMu.Lock()
+	list := make([]*LibraryPreset, 0, len(library))
+	for _, p := range library {
+		list = append(list, p)
+	}
+	libraryMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// messageID 不为 0 时在原消息上翻页
+func showLibraryPage(bot *tgbotapi.BotAPI, chatID int64, messageID int, page int) {
+	list := libraryList()
+	if len(list) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "📚 共享预设库暂时为空"))
+		return
+	}
+
+	pages := (len(list) + libraryPageSize - 1) / libraryPageSize
+	if page < 0 {
+		page = 0
+	}
+	if page >= pages {
+		page = pages - 1
+	}
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	start := page * libraryPageSize
+	for _, p := range list[start:min(start+libraryPageSize, len(list))] {
+		label := fmt.Sprintf("%s v%d · %.2f积分 · %d次使用", p.Name, p.Version, p.Cost, p.UsageCount)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, "lib_show:"+p.Name)))
+	}
+
+	nav := make([]tgbotapi.InlineKeyboardButton, 0, 2)
+	if page > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("⬅️ 上一页", fmt.Sprintf("lib_page:%d", page-1)))
+	}
+	if page < pages-1 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("下一页 ➡️", fmt.Sprintf("lib_page:%d", page+1)))
+	}
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+
+	text := fmt.Sprintf("📚 共享预设库（第 %d/%d 页）\n点击预设查看详情并使用", page+1, pages)
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if messageID != 0 {
+		bot.Send(tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text, markup))
+		return
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = markup
+	bot.Send(msg)
+}
+
+func showLibraryPreset(bot *tgbotapi.BotAPI, chatID int64, name string) {
+	libraryMu.Lock()
+	preset, ok := library[name]
+	var p LibraryPreset
+	if ok {
+		p = *preset
+	}
+	libraryMu.Unlock()
+
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 共享预设不存在"))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📚 %s v%d\n", p.Name, p.Version))
+	if p.Description != "" {
+		sb.WriteString(p.Description + "\n")
+	}
+	sb.WriteString(fmt.Sprintf("每次使用消耗 %.2f 积分 · 已被使用 %d 次 · 更新于 %s\n", p.Cost, p.UsageCount, p.UpdatedAt.Format("2006-01-02")))
+	if !p.Filter.IsZero() {
+		sb.WriteString("📂 目标文件：" + p.Filter.String() + "\n")
+	}
+	sb.WriteString("\n")
+	for _, pair := range p.Pairs {
+		sb.WriteString("▫️ " + pair.String() + "\n")
+	}
+
+	msg := tgbotapi.NewMessage(chatID, sb.String())
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("✅ 使用该预设", "lib_use:"+p.Name)),
+	)
+	bot.Send(msg)
+}
+
+/******************* 使用共享预设 *******************/
+func useLibraryPreset(bot *tgbotapi.BotAPI, chatID int64, user *User, name string) {
+	libraryMu.Lock()
+	preset, ok := library[name]
+	var p LibraryPreset
+	if ok {
+		p = *preset
+	}
+	libraryMu.Unlock()
+
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 共享预设不存在"))
+		return
+	}
+	if availablePoints(user) < p.Cost {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ 积分不足，该预设每次使用需要 %.2f 积分", p.Cost)))
+		return
+	}
+
+	if _, ok := processingUsers.Load(user.ID); !ok {
+		if !startBeautifySession(bot, user, chatID) {
+			return
+		}
+	}
+	loadPairsIntoSession(bot, chatID, user, p.Pairs, p.Filter, fmt.Sprintf("共享预设「%s」v%d（每次消耗%.2f积分）", p.Name, p.Version, p.Cost))
+
+	// 按预设价格计费
+	if data, ok := processingUsers.Load(user.ID); ok {
+		processData := data.(map[string]interface{})
+		processData["cost"] = p.Cost
+		processData["library_preset"] = p.Name
+		processingUsers.Store(user.ID, processData)
+	}
+
+}
+
+// 会话中的代码对或筛选规则被修改后不再是原预设，恢复按计费规则计费
+func clearLibraryPricing(bot *tgbotapi.BotAPI, chatID int64, processData map[string]interface{}) {
+	name, ok := processData["library_preset"].(string)
+	delete(processData, "cost")
+	delete(processData, "library_preset")
+	if ok {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("ℹ️ 已修改共享预设「%s」的内容，改为按默认计费规则计费", name)))
+	}
+}
+
+// 使用共享预设的任务成功完成后计入使用次数
+func recordLibraryUse(name string) {
+	libraryMu.Lock()
+	preset, ok := library[name]
+	if ok {
+		preset.UsageCount++
+	}
+	libraryMu.Unlock()
+	if ok {
+		saveLibrary()
+	}
+}
+
+/******************* 加载/保存 共享预设 *******************/
+func loadLibrary() {
+	file, err := ioutil.ReadFile(libraryFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取共享预设文件失败: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(file, &library); err != nil {
+		log.Printf("解析共享预设文件失败: %v", err)
+	}
+}
+
+func saveLibrary() {
+	libraryMu.Lock()
+	data, err := json.MarshalIndent(library, "", "  ")
+	libraryMu.Unlock()
+	if err != nil {
+		log.Printf("序列化共享预设失败: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(libraryFile, data, 0644); err != nil {
+		log.Printf("保存共享预设失败: %v", err)
+	}
+}
@@ -2,8 +2,6 @@ package main
 
 import (
 	"archive/zip"
-	"bytes"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -44,6 +42,8 @@ type RedeemCode struct {
 	ExpiresAt time.Time `json:"expires_at"`
 	Used      bool      `json:"used"`
 	UsedBy    int64     `json:"used_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UsedAt    time.Time `json:"used_at"`
 }
 
 var (
@@ -56,6 +56,10 @@ var (
 	adminIDs        = []int64{123456789, 1234567}  // 管理员ID
 	codes           = make(map[string]*RedeemCode) // 卡密存储
 	codesFile       = "codes.json"
+	jobsFile        = "jobs.json"             // 美化任务记录
+	statsFile       = "stats.json"            // 每日统计数据
+	webAddr         = "127.0.0.1:8080"        // 管理后台监听地址，默认只监听本机，留空则不启动
+	webBaseURL      = "http://127.0.0.1:8080" // 管理后台对外访问地址，用于生成登录链接
 )
 
 const maxIdleTime = 10 * time.Minute // 最大空闲时间
@@ -73,6 +77,15 @@ func main() {
 
 	loadData()
 	loadCodes()
+	loadJobs()
+	loadStats()
+	loadPresets()
+	loadLibrary()
+	loadResultCache()
+	loadPricing()
+	loadReservations()
+	loadJobQueue()
+	releaseOrphanReservations()
 
 	// 捕获 SIGINT 信号 : Ctrl+C
 	signalChan := make(chan os.Signal, 1)
@@ -84,6 +97,12 @@ func main() {
 		log.Printf("收到信号: %v，正在保存数据并退出...", sig)
 		saveData()
 		saveCodes()
+		saveJobs()
+		saveStats()
+		savePresets()
+		saveLibrary()
+		saveResultCache()
+		saveJobQueue()
 		os.Exit(0)
 	}()
 
@@ -97,16 +116,38 @@ func main() {
 				lastActivity, ok := processData["last_activity"].(time.Time)
 				if ok && now.Sub(lastActivity) > maxIdleTime {
 					processingUsers.Delete(key)
+					clearPreviewFile(processData)
+					clearRevertFile(processData)
+					clearPendingFiles(processData)
 					log.Printf("处理会话超时，已清理: 用户ID=%d", key)
-					chatID := processData["chat_id"].(int64)
-					bot := processData["bot"].(*tgbotapi.BotAPI)
-					bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理会话超时，已结束本次修改任务。请重新开始。"))
+					chatID, ok := processData["chat_id"].(int64)
+					bot, hasBot := processData["bot"].(*tgbotapi.BotAPI)
+					if ok && hasBot {
+						bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理会话超时，已结束本次修改任务。请重新开始。"))
+					}
 				}
 				return true
 			})
 		}
 	}()
 
+	// 定期淘汰过期的结果缓存
+	go func() {
+		for {
+			time.Sleep(1 * time.Hour)
+			evictResultCache()
+			evictJobResults()
+		}
+	}()
+
+	// 启动美化任务队列，继续处理重启前未完成的任务
+	startJobWorkers(bot)
+
+	// 启动网页管理后台
+	if webAddr != "" {
+		go startWebServer(bot)
+	}
+
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 	updates := bot.GetUpdatesChan(u)
@@ -134,23 +175,10 @@ func handleMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 	chatID := message.Chat.ID
 
 	// 初始化用户
-	user, exists := users[userID]
-	if !exists {
-		user = &User{
-			ID:          userID,
-			Username:    message.From.UserName,
-			FirstName:   message.From.FirstName,
-			LastName:    message.From.LastName,
-			Points:      0,
-			LastCheckIn: time.Time{},
-			IsBanned:    false,
-		}
-		users[userID] = user
-		log.Printf("新用户注册: ID=%d, 用户名=%s", userID, user.Username)
-	}
+	user := registerUser(message.From)
 
 	// 封禁检查
-	if user.IsBanned {
+	if isBanned(user) {
 		msg := tgbotapi.NewMessage(chatID, "您已被封禁，无法使用机器人功能。")
 		bot.Send(msg)
 		log.Printf("被封禁用户尝试访问: ID=%d", userID)
@@ -175,7 +203,7 @@ func handleMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 	/***** 菜单 ****/
 	if message.IsCommand() && message.Command() == "start" {
 		msg := tgbotapi.NewMessage(chatID,
-			message.From.FirstName+" "+message.From.LastName+"你好，我是 tainshi_bot！👋\n使用  /redeem 卡密 来兑换积分 \n admin: @tszj666 ,卡密购买请联系天使,官方频道: @tszjnb666 \n· 请点击下面的按钮进行操作：")
+			message.From.FirstName+" "+message.From.LastName+"你好，我是 tainshi_bot！👋\n使用  /redeem 卡密 来兑换积分 \n使用  /preset 管理代码对预设 \n使用  /library 浏览共享预设 \n使用  /filter 设置要处理的文件 \n使用  /revert 还原美化前的文件 \n使用  /jobs 查看最近的任务并重新下载结果 \n admin: @tszj666 ,卡密购买请联系天使,官方频道: @tszjnb666 \n· 请点击下面的按钮进行操作：")
 		msg.ReplyMarkup = buttons
 		bot.Send(msg)
 		return
@@ -200,12 +228,59 @@ func handleMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 	
 	· 解禁用户 (/unban)
 		/unban <用户ID>
+	
+	· 登录网页管理后台 (/weblogin)
+		机器人会私聊发送一次性登录链接
+	
+	· 共享预设 (/libpreset)
+		/libpreset publish <名称> <积分> [描述]（换行后附上代码对）
+		/libpreset delete <名称>
+		/libpreset stats
+	
+	· 结果缓存 (/cache)
+		/cache stats
+		/cache charge on|off（命中缓存时是否扣积分）
+		/cache clear
+	
+	· 计费规则 (/pricing)
+		/pricing
+		/pricing set base|file|pair|mb|rate|free <值>
 	`)
 		msg.ReplyMarkup = buttons
 		bot.Send(msg)
 		return
 	}
 
+	/***** 代码对预设 ****/
+	if message.IsCommand() && message.Command() == "preset" {
+		handlePresetCommand(bot, message, user)
+		return
+	}
+
+	/***** 目标文件规则 ****/
+	if message.IsCommand() && message.Command() == "filter" {
+		handleFilterCommand(bot, message, user)
+		return
+	}
+
+	/***** 任务记录 ****/
+	if message.IsCommand() && message.Command() == "jobs" {
+		handleJobsCommand(bot, chatID, user)
+		return
+	}
+
+	/***** 还原文件 ****/
+	if message.IsCommand() && message.Command() == "revert" {
+		handleRevertCommand(bot, user, chatID)
+		return
+	}
+
+	/***** 共享预设库 ****/
+	if message.IsCommand() && message.Command() == "library" {
+		showLibraryPage(bot, chatID, 0, 0)
+		return
+	}
+
 	/***** 用户兑换命令处理 ****/
 	if strings.HasPrefix(message.Text, "/redeem ") {
 		code := strings.TrimSpace(strings.TrimPrefix(message.Text, "/redeem "))
@@ -234,8 +309,13 @@ func handleTextInput(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 	userID := message.From.ID
 	chatID := message.Chat.ID
 
-	user, exists := users[userID]
-	if !exists || user.IsBanned {
+	user, exists := lookupUser(userID)
+	if !exists || isBanned(user) {
+		return
+	}
+
+	// 命令由 handleMessage 处理
+	if message.IsCommand() {
 		return
 	}
 
@@ -251,37 +331,27 @@ func handleTextInput(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 		return
 	}
 
-	lines := strings.Split(message.Text, "\n")
-	validPairs := make([][2]int, 0)
-
-	for i, line := range lines {
-		parts := strings.Fields(line)
-		if len(parts) != 2 {
-			bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("第%d行格式错误，已跳过", i+1)))
-			continue
-		}
-
-		original, err1 := strconv.Atoi(parts[0])
-		newCode, err2 := strconv.Atoi(parts[1])
-		if err1 != nil || err2 != nil {
-			bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("第%d行包含无效数字，已跳过", i+1)))
-			continue
-		}
-
-		validPairs = append(validPairs, [2]int{original, newCode})
-	}
+	addPairsToSession(bot, chatID, userID, processData, message.Text)
+}
 
+// 解析代码对文本并追加到会话中，手动输入和上传 .txt 文件共用
+func addPairsToSession(bot *tgbotapi.BotAPI, chatID int64, userID int64, processData map[string]interface{}, text string) {
+	validPairs, issues := parsePairs(text)
 	if len(validPairs) == 0 {
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ 未找到有效的代码对，请重新输入"))
+		bot.Send(tgbotapi.NewMessage(chatID, formatParseReport(validPairs, issues)+"\n❌ 未找到有效的代码对，请重新输入"))
 		return
 	}
 
-	existingCodes := processData["codes"].([][2]int)
+	existingCodes, _ := processData["codes"].([]CodePair)
 	existingCodes = append(existingCodes, validPairs...)
 	processData["codes"] = existingCodes
+	processData["last_activity"] = time.Now()
+	clearLibraryPricing(bot, chatID, processData)
 	processingUsers.Store(userID, processData)
 
-	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ 已添加%d个代码对，当前共%d对。请继续输入或发送文件。", len(validPairs), len(existingCodes))))
+	bot.Send(tgbotapi.NewMessage(chatID, formatParseReport(validPairs, issues)+
+		fmt.Sprintf("\n\n✅ 已添加%d个代码对，当前共%d对。请继续输入或发送文件。", len(validPairs), len(existingCodes))))
+	sendPairAnalysis(bot, chatID, existingCodes, sessionOptions(processData))
 }
 
 /******************* 文件消息处理 *******************/
@@ -289,8 +359,8 @@ func handleFileMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 	userID := message.From.ID
 	chatID := message.Chat.ID
 
-	user, exists := users[userID]
-	if !exists || user.IsBanned || message.Document == nil {
+	user, exists := lookupUser(userID)
+	if !exists || isBanned(user) || message.Document == nil {
 		return
 	}
 
@@ -300,43 +370,76 @@ func handleFileMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 		return
 	}
 
-	// 下载文件到临时文件
-	tempFile, err := ioutil.TempFile("", "download_*.zip")
-	if err != nil {
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ 创建临时文件失败"))
-		return
-	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
+	fileName := message.Document.FileName
+	isTarget := archiveFormat(fileName) != "" || sessionFilter(userID).Match(fileName)
+	isPairs := strings.HasSuffix(fileName, ".txt")
 
-	fileURL, _ := bot.GetFileDirectURL(message.Document.FileID)
-	resp, err := http.Get(fileURL)
-	if err != nil {
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ 文件下载失败"))
+	// 美化任务进入队列，由任务在后台下载和处理；还原、预览和代码对文件立即处理
+	switch {
+	case isRevertSession(userID), sessionPreviewMode(userID) && isTarget, isPairs:
+	case collectsFiles(userID, message):
+		// 多文件模式或相册中的文件先收集，确认后一起处理
+		collectFile(bot, user, chatID, message.Document)
+		return
+	case isTarget:
+		quoteSessionJob(bot, user, chatID, []JobFile{documentJobFile(userID, message.Document)}, false)
+		return
+	default:
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 不支持的文件类型"))
 		return
 	}
-	defer resp.Body.Close()
 
-	if _, err = io.Copy(tempFile, resp.Body); err != nil {
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ 文件保存失败"))
-		return
+	var filePath string
+	usedCache := false
+
+	if cached := sessionPreviewFile(userID); cached != nil && cached.FileUniqueID == message.Document.FileUniqueID {
+		// 已预览过的同一文件，直接复用已下载的副本
+		filePath = cached.Path
+		usedCache = true
+	} else {
+		// 下载文件到临时文件
+		tempFile, err := ioutil.TempFile("", "download_*")
+		if err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ 创建临时文件失败"))
+			return
+		}
+		defer os.Remove(tempFile.Name())
+		defer tempFile.Close()
+
+		fileURL, _ := bot.GetFileDirectURL(message.Document.FileID)
+		resp, err := http.Get(fileURL)
+		if err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ 文件下载失败"))
+			return
+		}
+		defer resp.Body.Close()
+
+		if _, err = io.Copy(tempFile, resp.Body); err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ 文件保存失败"))
+			return
+		}
+		filePath = tempFile.Name()
 	}
 
 	switch {
-	case strings.HasSuffix(message.Document.FileName, ".zip"):
-		processZipFile(bot, user, chatID, tempFile.Name(), message)
-	case strings.HasSuffix(message.Document.FileName, ".dat"):
-		processSingleFile(bot, user, chatID, tempFile.Name(), message)
-	case strings.HasSuffix(message.Document.FileName, ".txt"):
-		processBatchFile(bot, user, chatID, tempFile.Name(), message)
+	case isRevertSession(userID):
+		// 还原会话中接收修改后的文件和补丁清单
+		handleRevertFile(bot, user, chatID, filePath, fileName)
+		return
+	case isPairs:
+		processBatchFile(bot, user, chatID, filePath, message)
 	default:
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ 不支持的文件类型"))
+		// 预览模式下只查找代码对
+		previewFile(bot, user, chatID, filePath, message.Document)
 	}
 
 	data, ok := processingUsers.Load(userID)
 	if ok {
 		processData := data.(map[string]interface{})
 		processData["last_activity"] = time.Now() // 更新最后活动时间
+	} else if usedCache {
+		// 会话已结束，清理复用过的预览缓存
+		os.Remove(filePath)
 	}
 }
 
@@ -381,6 +484,7 @@ func handleAdminCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 				targetUser.Points = 0
 			}
 		}
+		saveDataLocked()
 
 		bot.Send(tgbotapi.NewMessage(chatID,
 			fmt.Sprintf("✅ 用户 %d 积分已更新\n当前积分：%.2f", targetID, targetUser.Points)))
@@ -415,6 +519,7 @@ func handleAdminCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 			Points:    points,
 			ExpiresAt: expiresAt,
 			Used:      false,
+			CreatedAt: time.Now(),
 		}
 		saveCodes()
 		mu.Unlock()
@@ -484,6 +589,18 @@ func handleAdminCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
 		saveData()
 		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ 用户 %d 已被解禁", targetID)))
 
+	case "weblogin":
+		handleWebLogin(bot, message)
+
+	case "libpreset":
+		handleLibraryAdminCommand(bot, message)
+
+	case "cache":
+		handleCacheCommand(bot, message)
+
+	case "pricing":
+		handlePricingCommand(bot, message)
+
 	default:
 		bot.Send(tgbotapi.NewMessage(chatID, "❌ 未知的管理员命令"))
 	}
@@ -515,8 +632,9 @@ func handleRedeemCode(bot *tgbotapi.BotAPI, message *tgbotapi.Message, user *Use
 	user.Points += rc.Points
 	rc.Used = true
 	rc.UsedBy = user.ID
+	rc.UsedAt = time.Now()
 
-	saveData()
+	saveDataLocked()
 	saveCodes()
 
 	msg := fmt.Sprintf("🎉 卡密兑换成功！\n获得 %.2f 积分\n当前积分：%.2f", rc.Points, user.Points)
@@ -534,168 +652,192 @@ func generateCode(length int) string {
 	return string(b)
 }
 
-/******************* 修改文件函数 *******************/
-func modifyFileHex(fileContent []byte, A, B string) ([]byte, error) {
-	searchSeq1, _ := hex.DecodeString(A)
-	searchSeq2, _ := hex.DecodeString(B)
-
-	index1 := bytes.LastIndex(fileContent, searchSeq1)
-	index2 := bytes.LastIndex(fileContent, searchSeq2)
-
-	if index1 == -1 || index2 == -1 {
-		return nil, errors.New("未找到指定的搜索序列")
-	}
-
-	newContent := make([]byte, len(fileContent))
-	copy(newContent, fileContent)
-
-	copy(newContent[index2:index2+4], fileContent[index1:index1+4])
-	copy(newContent[index1:index1+4], fileContent[index2:index2+4])
-
-	return newContent, nil
+/******************* 美化操作处理 *******************/
+func handleAutoBeautify(bot *tgbotapi.BotAPI, user *User, chatID int64, message *tgbotapi.Message) {
+	startBeautifySession(bot, user, chatID)
 }
 
-/******************* 十进制转十六进制函数 *******************/
-func decToHex(decimal int) string {
-	hexStr := fmt.Sprintf("%08X", decimal)
-	parts := make([]string, 0, 4)
-	for i := 0; i < len(hexStr); i += 2 {
-		parts = append(parts, hexStr[i:i+2])
-	}
-	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
-		parts[i], parts[j] = parts[j], parts[i]
+// 开始新的美化会话，积分不足时返回 false
+func startBeautifySession(bot *tgbotapi.BotAPI, user *User, chatID int64) bool {
+	if availablePoints(user) < pricingRules().Base && freeJobsLeft(user.ID) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 积分不足，请先签到获取积分！"))
+		return false
 	}
-	return strings.Join(parts, "")
-}
 
-/******************* 美化操作处理 *******************/
-func handleAutoBeautify(bot *tgbotapi.BotAPI, user *User, chatID int64, message *tgbotapi.Message) {
-	if user.Points < 1 {
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ 积分不足，请先签到获取积分！"))
-		return
+	// 清理上一次会话遗留的预览缓存和收集的文件
+	if data, ok := processingUsers.Load(user.ID); ok {
+		clearPreviewFile(data.(map[string]interface{}))
+		clearPendingFiles(data.(map[string]interface{}))
 	}
 
 	processingUsers.Store(user.ID, map[string]interface{}{
 		"step":          "waiting_codes",
-		"codes":         make([][2]int, 0),
+		"codes":         make([]CodePair, 0),
 		"last_activity": time.Now(), // 初始化最后活动时间
 		"chat_id":       chatID,
 		"bot":           bot,
+		"options":       defaultBeautifyOptions(),
 	})
 
-	msg := tgbotapi.NewMessage(chatID, `🛠 请按以下格式发送代码对（每行两个十进制数字，用空格分隔），发送完成后请发送要处理的文件：
+	msg := tgbotapi.NewMessage(chatID, `🛠 请按以下格式发送代码对（每行两个代码，用空格分隔），发送完成后请发送要处理的文件：
 例如：
 1234 5678
-8765 4321`)
-	bot.Send(msg)
-}
+8765 4321
 
-/******************* zip文件处理 *******************/
-func processZipFile(bot *tgbotapi.BotAPI, user *User, chatID int64, zipPath string, message *tgbotapi.Message) {
-	// 从处理会话获取代码对
-	data, ok := processingUsers.Load(user.ID)
-	if !ok {
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理会话已过期"))
-		return
+也可以上传写有代码对的 .txt 文件，多次发送的代码对会合并
+
+代码支持十进制、0x 开头的十六进制数值、hex: 开头的原始字节序列（如 hex:D2040000）
+
+每行末尾可选填以下选项：
+位宽 u16 · u32 · u64（默认 u32）
+字节序 le 小端 · be 大端（默认 le）
+匹配策略 last 最后一处 · first 第一处 · all 全部 · nth:N 第N处 · range:起始-结束 偏移区间内全部（默认 last）
+例如：1234 5678 u16 be all
+
+代码对未找到时的处理方式（默认严格模式）：
+严格模式：任一代码对未找到即终止，不扣积分
+宽松模式：跳过未找到的代码对，仅在有内容被修改时扣积分
+
+有多个文件时可点击「多文件模式」，或以相册形式一次发送多个文件，确认后一起处理并合并为一个压缩包返回
+
+默认只处理压缩包中的 .dat 文件，可使用 /filter 设置要处理或排除的文件（如 /filter include *.dat *.bin）`)
+	buttons := beautifyOptionButtons()
+	if presetRows := presetButtonRows(user.ID); len(presetRows) > 0 {
+		// 展示已保存的预设，点击即可载入
+		msg.Text += "\n\n📦 也可以点击下方预设直接载入代码对"
+		buttons.InlineKeyboard = append(buttons.InlineKeyboard, presetRows...)
 	}
+	msg.ReplyMarkup = buttons
+	bot.Send(msg)
+	return true
+}
 
-	processData := data.(map[string]interface{})
-	codePairs, ok := processData["codes"].([][2]int)
-	if !ok || len(codePairs) == 0 {
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ 无效的代码对"))
-		processingUsers.Delete(user.ID)
-		return
+/******************* 压缩包处理 *******************/
+// 支持 .zip、.tar、.tar.gz/.tgz 和单文件 .gz，按原格式重建
+func processArchiveFile(bot *tgbotapi.BotAPI, job *BeautifyJob, user *User, archivePath string, fileName string) (jobErr error) {
+	chatID := job.ChatID
+	codePairs := job.Codes
+	opts := job.options()
+
+	// 相同输入和代码对命中结果缓存时直接发送
+	format := archiveFormat(fileName)
+	inputHash, _ := sha256File(archivePath)
+	cacheKey := resultCacheKey(inputHash, format, codePairs, opts)
+	if entry, hit := lookupResultCache(cacheKey); hit {
+		job.setStatus(bot, "⬆️ 正在上传结果...")
+		job.charged, job.output, jobErr = deliverCachedResult(bot, job, user, entry, archivePath, fileName, inputHash)
+		if !errors.Is(jobErr, os.ErrNotExist) {
+			return jobErr
+		}
+		// 缓存文件已不存在，照常处理
+		jobErr = nil
 	}
 
 	// 创建临时工作目录
-	workDir, err := ioutil.TempDir("", "zip_process_*")
+	workDir, err := ioutil.TempDir("", "archive_process_*")
 	if err != nil {
 		bot.Send(tgbotapi.NewMessage(chatID, "❌ 创建临时目录失败"))
-		processingUsers.Delete(user.ID)
-		return
+		return err
 	}
 	defer os.RemoveAll(workDir) // 确保清理
 
-	// 解压原始ZIP
-	if err = unzip(zipPath, workDir); err != nil {
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ 解压文件失败: "+err.Error()))
-		processingUsers.Delete(user.ID)
-		return
+	// 解压原始压缩包，嵌套压缩包与之共用解压额度
+	budget := newExtractBudget()
+	if err = extractArchive(format, archivePath, fileName, workDir, budget); err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, unzipErrorMessage(err)))
+		return err
 	}
 
-	// 处理目录中的.dat文件
-	if err = processDirectory(workDir, codePairs); err != nil {
+	// 处理目录中符合筛选规则的文件
+	result, err := processDirectory(workDir, budget, codePairs, opts)
+	if errors.Is(err, errJobCanceled) {
+		return errJobCanceled
+	}
+	if err != nil {
 		bot.Send(tgbotapi.NewMessage(chatID, "❌ 文件处理失败: "+err.Error()))
-		processingUsers.Delete(user.ID)
-		return
+		sendSwapReport(bot, chatID, result)
+		return err
 	}
 
-	// 创建内存缓冲区存放新ZIP
-	var zipBuffer bytes.Buffer
-	zipWriter := zip.NewWriter(&zipBuffer)
-
-	// 遍历文件树写入ZIP
-	err = filepath.Walk(workDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return err
-		}
-
-		relPath, _ := filepath.Rel(workDir, path)
-		zipEntry, err := zipWriter.Create(relPath)
-		if err != nil {
-			return err
-		}
+	// 没有符合规则的文件时提示调整筛选规则
+	if len(result.Targets) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "⚠️ 压缩包中没有符合规则（"+opts.Filter.String()+"）的文件，未扣除积分。可使用 /filter 调整要处理的文件"))
+		sendSwapReport(bot, chatID, result)
+		return errors.New("没有符合筛选规则的文件")
+	}
 
-		fileContent, err := os.ReadFile(path)
-		if err != nil {
-			return err
-		}
+	// 没有任何内容被修改时不生成文件也不扣积分
+	if len(result.Files) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, unchangedNotice(result.Records)))
+		sendSwapReport(bot, chatID, result)
+		return errors.New("文件未被修改")
+	}
 
-		_, err = zipEntry.Write(fileContent)
+	// 新压缩包写入临时文件，按原压缩包的条目顺序重建
+	outFile, err := ioutil.TempFile("", "archive_output_*")
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 创建临时文件失败"))
 		return err
-	})
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
 
-	if err != nil {
+	if err = repackArchive(format, archivePath, fileName, workDir, changedPaths(result.Files), outFile); err != nil {
 		bot.Send(tgbotapi.NewMessage(chatID, "❌ 创建压缩文件失败: "+err.Error()))
-		processingUsers.Delete(user.ID)
-		return
+		return err
 	}
-
-	// 必须显式关闭zipWriter以确保数据写入
-	if err = zipWriter.Close(); err != nil {
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ 压缩文件关闭失败: "+err.Error()))
-		processingUsers.Delete(user.ID)
-		return
+	outputHash, err := sha256File(outFile.Name())
+	if err == nil {
+		_, err = outFile.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 读取压缩文件失败: "+err.Error()))
+		return err
 	}
 
-	// 扣除积分
-	mu.Lock()
-	user.Points -= 1
-	mu.Unlock()
-	saveData()
+	// 开始上传后不再响应取消
+	if opts.Control.Canceled() {
+		return errJobCanceled
+	}
 
 	// 构造友好文件名
-	originalName := filepath.Base(message.Document.FileName)
-	newName := "modified_" + strings.TrimSuffix(originalName, filepath.Ext(originalName)) + ".zip"
+	newName := modifiedFileName(fileName)
 
-	// 发送ZIP文件
-	file := tgbotapi.FileBytes{
-		Name:  newName,
-		Bytes: zipBuffer.Bytes(),
+	// 发送压缩包，发送成功后才扣除冻结的积分
+	price := job.Cost
+	job.setStatus(bot, "⬆️ 正在上传结果...")
+	file := tgbotapi.FileReader{
+		Name:   newName,
+		Reader: outFile,
 	}
 	msg := tgbotapi.NewDocument(chatID, file)
-	msg.Caption = fmt.Sprintf("✅ 美化完成！消耗1积分，剩余积分: %.2f", user.Points)
-	if _, err := bot.Send(msg); err != nil {
+	msg.Caption = fmt.Sprintf("✅ 美化完成！消耗%.2f积分，剩余积分: %.2f", price, balanceAfterCommit(user, job.ID, price))
+	sent, err := bot.Send(msg)
+	if err != nil {
 		log.Printf("发送文件失败: %v", err)
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ 发送文件失败，请联系管理员"))
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 发送文件失败，积分已退回，请稍后重试"))
+		return err
 	}
-
-	// 清理处理会话
-	processingUsers.Delete(user.ID)
+	job.charged = commitCharge(user, job, price)
+	job.output = saveJobOutput(job.ID, outFile.Name(), newName, sentFileID(sent))
+
+	sendSwapReport(bot, chatID, result)
+	sendPatchManifest(bot, chatID, &PatchManifest{
+		Version:      patchManifestVersion,
+		InputName:    fileName,
+		InputSHA256:  inputHash,
+		OutputName:   newName,
+		OutputSHA256: outputHash,
+		CreatedAt:    time.Now(),
+		Files:        result.Files,
+		Archive:      archivePatchFor(archivePath, outFile.Name()),
+	})
+	storeResultCache(cacheKey, outFile.Name(), result)
+	return nil
 }
 
 /******************* .zip压缩包处理 *******************/
-func processZipArchive(inputPath string, codes [][2]int) (string, error) {
+func processZipArchive(inputPath string, codes []CodePair) (string, error) {
 	// 创建临时工作目录
 	workDir, err := ioutil.TempDir("", "bot_processing_*")
 	if err != nil {
@@ -704,18 +846,26 @@ func processZipArchive(inputPath string, codes [][2]int) (string, error) {
 	defer os.RemoveAll(workDir)
 
 	// 解压zip文件
-	if err := unzip(inputPath, workDir); err != nil {
+	budget := newExtractBudget()
+	if err := unzip(inputPath, workDir, budget); err != nil {
 		return "", fmt.Errorf("解压zip文件失败: %w", err)
 	}
 
 	// 处理目录中的.dat文件
-	if err := processDirectory(workDir, codes); err != nil {
+	result, err := processDirectory(workDir, budget, codes, defaultBeautifyOptions())
+	if err != nil {
 		return "", err
 	}
 
 	// 创建新的zip文件
 	outputPath := filepath.Join(os.TempDir(), "processed_"+filepath.Base(inputPath))
-	if err := createZipArchive(outputPath, workDir); err != nil {
+	outFile, err := os.Create(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("创建zip文件失败: %w", err)
+	}
+	defer outFile.Close()
+
+	if err := repackZip(inputPath, workDir, changedPaths(result.Files), outFile); err != nil {
 		return "", err
 	}
 
@@ -723,38 +873,36 @@ func processZipArchive(inputPath string, codes [][2]int) (string, error) {
 }
 
 /******************* 解压 *******************/
-func unzip(src, dest string) error {
+func unzip(src, dest string, budget *extractBudget) error {
 	r, err := zip.OpenReader(src)
 	if err != nil {
 		return err
 	}
 	defer r.Close()
 
-	for _, f := range r.File {
-		filePath := filepath.Join(dest, f.Name)
-
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(filePath, os.ModePerm)
-			continue
-		}
+	if err := checkZipArchive(r.File, budget); err != nil {
+		return err
+	}
 
-		if err = os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+	for _, f := range r.File {
+		filePath, err := safeJoin(dest, f.Name)
+		if err != nil {
 			return err
 		}
-
-		outFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
+		if err := budget.addEntry(); err != nil {
 			return err
 		}
-		defer outFile.Close()
 
-		rc, err := f.Open()
-		if err != nil {
-			return err
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(filePath, 0755); err != nil {
+				return err
+			}
+			continue
 		}
-		defer rc.Close()
 
-		if _, err := io.Copy(outFile, rc); err != nil {
+		n, err := extractZipEntry(f, filePath, budget.bytes)
+		budget.bytes -= n
+		if err != nil {
 			return err
 		}
 	}
@@ -762,130 +910,196 @@ func unzip(src, dest string) error {
 	return nil
 }
 
-/******************* 创建zip压缩包 *******************/
-func createZipArchive(outputPath string, sourceDir string) error {
-	zipFile, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("创建zip文件失败: %w", err)
-	}
-	defer zipFile.Close()
-
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
-
-	return filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+/******************* 递归处理目录 *******************/
+// budget 为解压 dir 后剩余的额度，其中的嵌套压缩包继续从中扣除
+func processDirectory(dir string, budget *extractBudget, codes []CodePair, opts *BeautifyOptions) (*BeautifyResult, error) {
+	return processDirectoryAt(dir, "", 0, budget, codes, opts)
+}
 
-		relPath, err := filepath.Rel(sourceDir, path)
+// prefix 为嵌套压缩包在报告中的路径前缀，depth 为当前所在的嵌套层数
+func processDirectoryAt(dir, prefix string, depth int, budget *extractBudget, codes []CodePair, opts *BeautifyOptions) (*BeautifyResult, error) {
+	// 先按遍历顺序收集要处理的文件，处理结果按下标保存，输出顺序与并发调度无关
+	type dirTask struct {
+		path    string
+		relPath string
+		format  string // 非空表示嵌套压缩包
+		records []SwapRecord
+		nested  *BeautifyResult
+		patch   *PatchFile
+	}
+	tasks := make([]*dirTask, 0)
+	result := newBeautifyResult()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-
 		if info.IsDir() {
 			return nil
 		}
 
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-
-		zipEntry, err := zipWriter.Create(relPath)
-		if err != nil {
-			return err
+		relPath, _ := filepath.Rel(dir, path)
+		relPath = filepath.ToSlash(relPath)
+		switch {
+		case archiveFormat(info.Name()) != "" && depth < maxNestedDepth && !opts.Filter.Excluded(relPath):
+			tasks = append(tasks, &dirTask{path: path, relPath: relPath, format: archiveFormat(info.Name())})
+		case opts.Filter.Match(relPath):
+			tasks = append(tasks, &dirTask{path: path, relPath: relPath})
+		default:
+			result.Skipped = append(result.Skipped, prefix+relPath)
 		}
-
-		if _, err := io.Copy(zipEntry, file); err != nil {
-			return err
-		}
-
 		return nil
 	})
-}
+	if err != nil {
+		return result, err
+	}
 
-/******************* 递归处理目录 *******************/
-func processDirectory(dir string, codes [][2]int) error {
-	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	// 普通文件交给协程池并发处理
+	files := make([]*dirTask, 0, len(tasks))
+	for _, task := range tasks {
+		if task.format == "" {
+			files = append(files, task)
 		}
-		if info.IsDir() {
-			return nil
+	}
+	errs := make([]error, 0)
+	if err := runBounded(len(files), func(i int) error {
+		if opts.Control.Canceled() {
+			return errJobCanceled
 		}
-		if strings.HasSuffix(info.Name(), ".dat") {
-			content, err := os.ReadFile(path)
-			if err != nil {
-				return err
-			}
+		var err error
+		files[i].records, files[i].patch, err = patchFile(files[i].path, prefix+files[i].relPath, codes, opts)
+		opts.Control.filePatched()
+		return err
+	}); err != nil {
+		errs = append(errs, err)
+	}
 
-			for _, codePair := range codes {
-				modified, err := modifyFileHex(content, decToHex(codePair[0]), decToHex(codePair[1]))
-				if err != nil {
-					return err
-				}
-				content = modified
-			}
+	// 嵌套压缩包在当前协程中依次处理，内层文件同样使用协程池，避免占着槽位等待内层任务
+	for _, task := range tasks {
+		if task.format == "" || opts.Control.Canceled() {
+			continue
+		}
+		var err error
+		task.nested, task.patch, err = processNestedArchive(task.path, prefix+task.relPath, task.format, depth+1, budget, codes, opts)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
 
-			if err := os.WriteFile(path, content, 0644); err != nil {
-				return err
-			}
+	for _, task := range tasks {
+		if task.format != "" {
+			result.merge(task.nested)
+		} else {
+			result.Records = append(result.Records, task.records...)
+			result.Targets = append(result.Targets, prefix+task.relPath)
 		}
-		return nil
-	})
+		if task.patch != nil {
+			task.patch.Path = task.relPath
+			result.Files = append(result.Files, *task.patch)
+		}
+	}
+	if opts.Control.Canceled() {
+		return result, errJobCanceled
+	}
+	return result, errors.Join(errs...)
 }
 
-/******************* 单个文件处理 *******************/
-func processSingleFile(bot *tgbotapi.BotAPI, user *User, chatID int64, filePath string, message *tgbotapi.Message) {
-	// 检查处理会话
-	data, ok := processingUsers.Load(user.ID)
-	if !ok {
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理会话已过期"))
-		return
+/******************* 依次应用代码对 *******************/
+// 严格模式下遇到未找到的代码对立即返回错误；宽松模式下记录后跳过
+// 所有代码对的搜索序列一次扫描建立索引，交换在同一份副本上原地进行，content 本身不会被修改
+func applyCodePairs(content []byte, file string, codes []CodePair, opts *BeautifyOptions) ([]byte, []SwapRecord, error) {
+	index := newPatternIndex(append([]byte(nil), content...), codes)
+	records, err := swapPairs(index, file, codes, opts)
+	if err != nil {
+		return nil, records, err
 	}
+	return index.content, records, nil
+}
 
-	processData := data.(map[string]interface{})
-	codes, ok := processData["codes"].([][2]int)
-	if !ok || len(codes) == 0 {
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ 未找到有效的代码对"))
-		processingUsers.Delete(user.ID)
-		return
-	}
+/******************* 单个文件处理 *******************/
+func processSingleFile(bot *tgbotapi.BotAPI, job *BeautifyJob, user *User, filePath string, fileName string) (jobErr error) {
+	chatID := job.ChatID
+	codes := job.Codes
+	opts := job.options()
 
-	// 读取文件内容
-	content, err := os.ReadFile(filePath)
+	// 计算输入文件哈希
+	inputHash, err := sha256File(filePath)
 	if err != nil {
 		bot.Send(tgbotapi.NewMessage(chatID, "❌ 读取文件失败"))
-		processingUsers.Delete(user.ID)
-		return
+		return err
 	}
 
-	// 应用所有代码对
-	for _, pair := range codes {
-		modified, err := modifyFileHex(content, decToHex(pair[0]), decToHex(pair[1]))
-		if err != nil {
-			bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理失败: "+err.Error()))
-			processingUsers.Delete(user.ID)
-			return
+	// 相同输入和代码对命中结果缓存时直接发送
+	cacheKey := resultCacheKey(inputHash, "dat", codes, opts)
+	if entry, hit := lookupResultCache(cacheKey); hit {
+		job.setStatus(bot, "⬆️ 正在上传结果...")
+		job.charged, job.output, jobErr = deliverCachedResult(bot, job, user, entry, filePath, fileName, inputHash)
+		if !errors.Is(jobErr, os.ErrNotExist) {
+			return jobErr
 		}
-		content = modified
+		// 缓存文件已不存在，照常处理
+		jobErr = nil
 	}
 
-	// 扣除积分
-	mu.Lock()
-	user.Points -= 1
-	mu.Unlock()
+	// 在临时副本上应用所有代码对
+	workDir, err := ioutil.TempDir("", "file_process_*")
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 创建临时目录失败"))
+		return err
+	}
+	defer os.RemoveAll(workDir)
 
-	// 发送结果
-	sendModifiedFile(bot, chatID, content, message.Document.FileName)
+	outPath := filepath.Join(workDir, "output")
+	if err = copyFile(filePath, outPath); err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 读取文件失败"))
+		return err
+	}
 
-	// 清理会话
-	processingUsers.Delete(user.ID)
+	records, patch, err := patchFile(outPath, fileName, codes, opts)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理失败: "+err.Error()))
+		sendSwapReport(bot, chatID, &BeautifyResult{Records: records})
+		return err
+	}
+	opts.Control.filePatched()
+
+	// 没有任何内容被修改时不发送文件也不扣积分
+	if patch == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, unchangedNotice(records)))
+		sendSwapReport(bot, chatID, &BeautifyResult{Records: records})
+		return errors.New("文件未被修改")
+	}
+
+	// 开始上传后不再响应取消
+	if opts.Control.Canceled() {
+		return errJobCanceled
+	}
+
+	// 发送结果，发送成功后才扣除冻结的积分
+	price := job.Cost
+	job.setStatus(bot, "⬆️ 正在上传结果...")
+	result := &BeautifyResult{Records: records, Files: []PatchFile{*patch}}
+	newFileName, fileID := sendModifiedFile(bot, chatID, outPath, fileName, price, balanceAfterCommit(user, job.ID, price))
+	if fileID == "" {
+		return errors.New("发送文件失败")
+	}
+	job.charged = commitCharge(user, job, price)
+	job.output = saveJobOutput(job.ID, outPath, newFileName, fileID)
+	sendSwapReport(bot, chatID, result)
+	sendPatchManifest(bot, chatID, &PatchManifest{
+		Version:      patchManifestVersion,
+		InputName:    fileName,
+		InputSHA256:  inputHash,
+		OutputName:   newFileName,
+		OutputSHA256: patch.OutputSHA256,
+		CreatedAt:    time.Now(),
+		Files:        result.Files,
+	})
+	storeResultCache(cacheKey, outPath, result)
+	return nil
 }
 
-/******************* 批量文件处理 *******************/
+/******************* 代码对文件处理 *******************/
+// 上传的 .txt 文件与手动输入的代码对一样追加到当前会话，没有会话时自动开始美化
 func processBatchFile(bot *tgbotapi.BotAPI, user *User, chatID int64, filePath string, message *tgbotapi.Message) {
 	content, err := os.ReadFile(filePath)
 	if err != nil {
@@ -893,56 +1107,78 @@ func processBatchFile(bot *tgbotapi.BotAPI, user *User, chatID int64, filePath s
 		return
 	}
 
-	lines := strings.Split(string(content), "\n")
-	codeList := make([][2]int, 0)
-
-	for _, line := range lines {
-		parts := strings.Fields(line)
-		if len(parts) != 2 {
-			continue
-		}
-		original, err1 := strconv.Atoi(parts[0])
-		newCode, err2 := strconv.Atoi(parts[1])
-		if err1 != nil || err2 != nil {
-			continue
+	if _, ok := processingUsers.Load(user.ID); !ok {
+		if !startBeautifySession(bot, user, chatID) {
+			return
 		}
-		codeList = append(codeList, [2]int{original, newCode})
 	}
-
-	if len(codeList) == 0 {
-		bot.Send(tgbotapi.NewMessage(chatID, "❌ 未找到有效的代码对"))
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理会话已过期"))
 		return
 	}
+	addPairsToSession(bot, chatID, user.ID, data.(map[string]interface{}), string(content))
+}
 
-	// 存储代码对用于后续处理
-	processingUsers.Store(user.ID, map[string]interface{}{
-		"codes": codeList,
-		"file":  filePath,
-	})
+/******************* 发送修改后的文件 *******************/
+// 返回发送的文件名和 Telegram 返回的 file_id，发送失败时 file_id 为空
+func sendModifiedFile(bot *tgbotapi.BotAPI, chatID int64, path string, originalFileName string, cost, balance float64) (string, string) {
+	newFileName := modifiedFileName(originalFileName)
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("打开结果文件失败: %v", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 发送文件失败，积分已退回，请稍后重试"))
+		return newFileName, ""
+	}
+	defer f.Close()
+
+	// 使用 FileReader 流式上传并指定文件名
+	file := tgbotapi.FileReader{
+		Name:   newFileName,
+		Reader: f,
+	}
 
-	bot.Send(tgbotapi.NewMessage(chatID, "✅ 代码对已接收，请发送要处理的文件或压缩包"))
+	msg := tgbotapi.NewDocument(chatID, file)
+	msg.Caption = fmt.Sprintf("✅ 文件美化完成！消耗%.2f积分，剩余积分: %.2f", cost, balance)
+	sent, err := bot.Send(msg)
+	if err != nil {
+		log.Printf("发送文件失败: %v", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 发送文件失败，积分已退回，请稍后重试"))
+		return newFileName, ""
+	}
+	return newFileName, sentFileID(sent)
 }
 
-/******************* 发送修改后的文件 *******************/
-func sendModifiedFile(bot *tgbotapi.BotAPI, chatID int64, content []byte, originalFileName string) {
-	// 保留原始文件后缀
+// 构造友好文件名，保留原始文件后缀
+func modifiedFileName(originalFileName string) string {
 	ext := filepath.Ext(originalFileName)
 	if ext == "" {
 		ext = ".dat"
 	}
-
-	// 构造友好文件名
 	baseName := strings.TrimSuffix(filepath.Base(originalFileName), ext)
-	newFileName := "modified_" + baseName + ext
+	return "modified_" + baseName + ext
+}
+
+/******************* 发送处理报告 *******************/
+func sendSwapReport(bot *tgbotapi.BotAPI, chatID int64, result *BeautifyResult) {
+	selection := formatFileSelection(result)
+	if len(result.Records) == 0 && selection == "" {
+		return
+	}
 
-	// 使用 FileBytes 并指定文件名
-	file := tgbotapi.FileBytes{
-		Name:  newFileName,
-		Bytes: content,
+	report := selection
+	if len(result.Records) > 0 {
+		report += formatSwapReport(result.Records)
+	}
+	if len(report) <= 4000 {
+		bot.Send(tgbotapi.NewMessage(chatID, report))
+		return
 	}
 
-	msg := tgbotapi.NewDocument(chatID, file)
-	msg.Caption = "✅ 文件美化完成！消耗1积分"
+	// 超过消息长度限制时以文件形式发送
+	msg := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: "report.txt", Bytes: []byte(report)})
+	msg.Caption = "📋 处理报告"
 	bot.Send(msg)
 }
 
@@ -952,21 +1188,10 @@ func handleCallback(bot *tgbotapi.BotAPI, callback *tgbotapi.CallbackQuery) {
 	chatID := callback.Message.Chat.ID
 
 	// 初始化用户
-	user, exists := users[userID]
-	if !exists {
-		user = &User{
-			ID:          userID,
-			Username:    callback.From.UserName,
-			Points:      0,
-			LastCheckIn: time.Time{},
-			IsBanned:    false,
-		}
-		users[userID] = user
-		log.Printf("新用户注册: ID=%d, 用户名=%s", userID, user.Username)
-	}
+	user := registerUser(callback.From)
 
 	// 封禁检查
-	if user.IsBanned {
+	if isBanned(user) {
 		bot.Send(tgbotapi.NewMessage(chatID, "您已被封禁，无法使用机器人功能。"))
 		log.Printf("被封禁用户尝试访问: ID=%d", userID)
 		return
@@ -980,13 +1205,55 @@ func handleCallback(bot *tgbotapi.BotAPI, callback *tgbotapi.CallbackQuery) {
 		info(bot, chatID, user)
 	case "auto_biuf":
 		handleAutoBeautify(bot, user, chatID, callback.Message)
+	case "policy_strict":
+		setBeautifyPolicy(bot, user, chatID, PolicyStrict)
+	case "policy_lenient":
+		setBeautifyPolicy(bot, user, chatID, PolicyLenient)
+	case "semantics_sequential":
+		setBeautifySemantics(bot, user, chatID, SemanticsSequential)
+	case "semantics_simultaneous":
+		setBeautifySemantics(bot, user, chatID, SemanticsSimultaneous)
+	case "preview_on":
+		setPreviewMode(bot, user, chatID, true)
+	case "preview_off":
+		setPreviewMode(bot, user, chatID, false)
+	case "preview_run":
+		runPreviewedFile(bot, user, chatID)
+	case "multi_on":
+		setMultiFileMode(bot, user, chatID)
+	case "multi_run":
+		runPendingFiles(bot, user, chatID)
+	case "multi_clear":
+		discardPendingFiles(bot, user, chatID)
+	case "quote_confirm":
+		confirmQuote(bot, user, chatID)
+	case "quote_cancel":
+		cancelQuote(bot, user, chatID)
+	default:
+		data := callback.Data
+		if name, ok := strings.CutPrefix(data, "preset_use:"); ok {
+			usePreset(bot, chatID, user, name)
+		} else if page, ok := strings.CutPrefix(data, "lib_page:"); ok {
+			n, _ := strconv.Atoi(page)
+			showLibraryPage(bot, chatID, callback.Message.MessageID, n)
+		} else if name, ok := strings.CutPrefix(data, "lib_show:"); ok {
+			showLibraryPreset(bot, chatID, name)
+		} else if name, ok := strings.CutPrefix(data, "lib_use:"); ok {
+			useLibraryPreset(bot, chatID, user, name)
+		} else if id, ok := strings.CutPrefix(data, "job_cancel:"); ok {
+			cancelJob(bot, user, chatID, id)
+		} else if id, ok := strings.CutPrefix(data, "job_get:"); ok {
+			resendJobOutput(bot, chatID, user, id)
+		}
 	}
 }
 
 /******************* 签到功能 *******************/
 func sign(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	mu.Lock()
 	// 检查是否已经签到过
 	if time.Since(user.LastCheckIn).Hours() < 24 {
+		mu.Unlock()
 		msg := tgbotapi.NewMessage(chatID, "您今日已签到，请明天再来！")
 		bot.Send(msg)
 		return
@@ -1001,9 +1268,13 @@ func sign(bot *tgbotapi.BotAPI, chatID int64, user *User) {
 
 	// 更新最后签到时间
 	user.LastCheckIn = time.Now()
+	checkInAt, points := user.LastCheckIn, user.Points
+	saveDataLocked()
+	mu.Unlock()
+	recordCheckIn(checkInAt)
 
 	// 发送签到成功消息
-	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("签到成功！当前积分: *%.2f*", user.Points))
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("签到成功！当前积分: *%s*", escapeMarkdownV2(fmt.Sprintf("%.2f", points))))
 	msg.ParseMode = tgbotapi.ModeMarkdownV2 // 启用 MarkdownV2 解析模式
 	bot.Send(msg)
 
@@ -1011,11 +1282,17 @@ func sign(bot *tgbotapi.BotAPI, chatID int64, user *User) {
 	successMsg := tgbotapi.NewMessage(chatID, "🎉 恭喜您签到成功，积分已增加！")
 	bot.Send(successMsg)
 
-	log.Printf("用户签到成功: ID=%d, 用户名=%s, 积分=%.2f", user.ID, user.Username, user.Points)
+	log.Printf("用户签到成功: ID=%d, 用户名=%s, 积分=%.2f", user.ID, user.Username, points)
 }
 
 /******************* 查看信息功能 *******************/
 func info(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	mu.Lock()
+	snapshot := *user
+	reserved := reservedPointsLocked(user.ID)
+	mu.Unlock()
+	user = &snapshot
+
 	lastCheckIn := "从未签到"
 	if !user.LastCheckIn.IsZero() {
 		lastCheckIn = user.LastCheckIn.Format("2006-01-02 15:04:05")
@@ -1044,8 +1321,9 @@ func info(bot *tgbotapi.BotAPI, chatID int64, user *User) {
 		"%s \\(@%s\\) 的信息\n"+
 			"  \\- *用户ID*: `%d`\n"+
 			"  \\- *积分*: `%.2f`\n"+
+			"  \\- *冻结积分*: `%.2f`\n"+
 			"  \\- *最后签到时间*: `%s`",
-		displayName, escapedUsername, user.ID, user.Points, escapedLastCheckIn,
+		displayName, escapedUsername, user.ID, user.Points, reserved, escapedLastCheckIn,
 	))
 	msg.ParseMode = tgbotapi.ModeMarkdownV2 // 启用 MarkdownV2 解析模式
 	_, err := bot.Send(msg)
@@ -1091,6 +1369,41 @@ func saveCodes() {
 	}
 }
 
+/******************* 用户查找与注册 *******************/
+// 首次出现的用户自动注册
+func registerUser(from *tgbotapi.User) *User {
+	mu.Lock()
+	defer mu.Unlock()
+	user, exists := users[from.ID]
+	if !exists {
+		user = &User{
+			ID:          from.ID,
+			Username:    from.UserName,
+			FirstName:   from.FirstName,
+			LastName:    from.LastName,
+			Points:      0,
+			LastCheckIn: time.Time{},
+			IsBanned:    false,
+		}
+		users[from.ID] = user
+		log.Printf("新用户注册: ID=%d, 用户名=%s", from.ID, user.Username)
+	}
+	return user
+}
+
+func lookupUser(userID int64) (*User, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	user, exists := users[userID]
+	return user, exists
+}
+
+func isBanned(user *User) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return user.IsBanned
+}
+
 /******************* 加载/保存 用户数据 *******************/
 func loadData() {
 	file, err := ioutil.ReadFile(dataFile)
@@ -1109,6 +1422,13 @@ func loadData() {
 }
 
 func saveData() {
+	mu.Lock()
+	defer mu.Unlock()
+	saveDataLocked()
+}
+
+// 调用方需持有 mu
+func saveDataLocked() {
 	data, err := json.MarshalIndent(users, "", "  ")
 	if err != nil {
 		log.Printf("序列化用户数据失败: %v", err)
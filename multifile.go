@@ -0,0 +1,298 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 多文件任务的限制
+var (
+	maxMultiFiles     = 20                       // 单个任务最多收集的文件数
+	maxMultiFileBytes = int64(200 * 1024 * 1024) // 收集文件的总大小上限 200MB
+)
+
+// 多文件任务合并返回的压缩包名称
+const multiFileArchiveName = "files.zip"
+
+// 会话中已收集、等待一起处理的文件，确认后由任务统一下载
+type PendingFiles struct {
+	Files     []JobFile // 按收到的顺序排列，文件名已去重
+	Size      int64
+	MessageID int // 收集进度消息，收到新文件时更新
+}
+
+func (p *PendingFiles) names() []string {
+	names := make([]string, 0, len(p.Files))
+	for _, f := range p.Files {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+/******************* 切换多文件模式 *******************/
+func setMultiFileMode(bot *tgbotapi.BotAPI, user *User, chatID int64) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 当前没有进行中的美化任务，请先点击「自动美化」"))
+		return
+	}
+
+	processData := data.(map[string]interface{})
+	processData["last_activity"] = time.Now()
+	opts := sessionOptions(processData)
+	opts.MultiFile = true
+	processData["options"] = opts
+	processingUsers.Store(user.ID, processData)
+
+	bot.Send(tgbotapi.NewMessage(chatID, "📦 已开启多文件模式：请逐个或一次性（相册形式）发送要处理的文件，全部发送后点击「开始处理」，结果合并为一个压缩包返回。\n整个任务只扣一次积分，开始前会发送报价供确认"))
+}
+
+// 是否收集该文件等待一起处理：开启了多文件模式，或文件是相册（media group）中的一个
+func collectsFiles(userID int64, message *tgbotapi.Message) bool {
+	data, ok := processingUsers.Load(userID)
+	if !ok {
+		return false
+	}
+	return message.MediaGroupID != "" || sessionOptions(data.(map[string]interface{})).MultiFile
+}
+
+/******************* 收集文件 *******************/
+func collectFile(bot *tgbotapi.BotAPI, user *User, chatID int64, document *tgbotapi.Document) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理会话已过期"))
+		return
+	}
+	processData := data.(map[string]interface{})
+
+	fileName := filepath.Base(strings.ReplaceAll(document.FileName, "\\", "/"))
+	if archiveFormat(fileName) == "" && !sessionFilter(user.ID).Match(fileName) {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 不支持的文件类型: "+fileName))
+		return
+	}
+
+	pending, _ := processData["pending_files"].(*PendingFiles)
+	if pending == nil {
+		pending = &PendingFiles{}
+		processData["pending_files"] = pending
+	}
+
+	if len(pending.Files) >= maxMultiFiles {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ 每个任务最多处理 %d 个文件，%s 未加入", maxMultiFiles, fileName)))
+		return
+	}
+	size := int64(document.FileSize)
+	if pending.Size+size > maxMultiFileBytes {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ 文件总大小超过 %dMB，%s 未加入", maxMultiFileBytes/1024/1024, fileName)))
+		return
+	}
+
+	file := documentJobFile(user.ID, document)
+	file.Name = uniqueFileName(pending.names(), fileName)
+	pending.Files = append(pending.Files, file)
+	pending.Size += size
+	processData["last_activity"] = time.Now()
+	processingUsers.Store(user.ID, processData)
+
+	updatePendingMessage(bot, chatID, pending)
+}
+
+// 同名文件加上序号，例如 a.dat、a (2).dat
+func uniqueFileName(names []string, name string) string {
+	ext := filepath.Ext(name)
+	if strings.HasSuffix(strings.ToLower(name), ".tar.gz") {
+		ext = name[len(name)-len(".tar.gz"):]
+	}
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; containsString(names, candidate); i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	return candidate
+}
+
+// 发送或更新收集进度消息，相册中的多个文件只占用一条消息
+func updatePendingMessage(bot *tgbotapi.BotAPI, chatID int64, pending *PendingFiles) {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📦 已收到 %d 个文件（%.1fMB）：\n", len(pending.Files), float64(pending.Size)/1024/1024))
+	for i, f := range pending.Files {
+		sb.WriteString(fmt.Sprintf("%d. %s\n", i+1, f.Name))
+	}
+	sb.WriteString("\n继续发送文件，或点击「开始处理」")
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("✅ 开始处理（%d个文件）", len(pending.Files)), "multi_run"),
+			tgbotapi.NewInlineKeyboardButtonData("🗑 清空文件", "multi_clear"),
+		),
+	)
+
+	if pending.MessageID != 0 {
+		edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, pending.MessageID, sb.String(), markup)
+		if _, err := bot.Send(edit); err == nil {
+			return
+		}
+	}
+	msg := tgbotapi.NewMessage(chatID, sb.String())
+	msg.ReplyMarkup = markup
+	if sent, err := bot.Send(msg); err == nil {
+		pending.MessageID = sent.MessageID
+	}
+}
+
+// 清空会话中收集的文件，其中复用的预览副本随预览缓存一起清理
+func clearPendingFiles(processData map[string]interface{}) {
+	delete(processData, "pending_files")
+}
+
+func discardPendingFiles(bot *tgbotapi.BotAPI, user *User, chatID int64) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理会话已过期"))
+		return
+	}
+	processData := data.(map[string]interface{})
+	clearPendingFiles(processData)
+	processData["last_activity"] = time.Now()
+	processingUsers.Store(user.ID, processData)
+	bot.Send(tgbotapi.NewMessage(chatID, "🗑 已清空收集的文件，可以重新发送"))
+}
+
+// 确认收集的文件，作为一个任务加入队列
+func runPendingFiles(bot *tgbotapi.BotAPI, user *User, chatID int64) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理会话已过期"))
+		return
+	}
+
+	pending, _ := data.(map[string]interface{})["pending_files"].(*PendingFiles)
+	if pending == nil || len(pending.Files) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 还没有收到要处理的文件"))
+		return
+	}
+	quoteSessionJob(bot, user, chatID, pending.Files, true)
+}
+
+/******************* 多文件任务处理 *******************/
+// 所有文件已下载到 dir 中一起处理（压缩包按嵌套压缩包处理），结果合并为一个 zip 返回并统一计费
+func processMultiFile(bot *tgbotapi.BotAPI, job *BeautifyJob, user *User, dir string, paths []string) (jobErr error) {
+	chatID := job.ChatID
+	codes := job.Codes
+	opts := job.options()
+
+	names := make([]string, 0, len(paths))
+	for _, path := range paths {
+		names = append(names, filepath.Base(path))
+	}
+
+	result, err := processDirectory(dir, newExtractBudget(), codes, opts)
+	if errors.Is(err, errJobCanceled) {
+		return errJobCanceled
+	}
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 文件处理失败: "+err.Error()))
+		sendSwapReport(bot, chatID, result)
+		return err
+	}
+
+	if len(result.Targets) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "⚠️ 没有符合规则（"+opts.Filter.String()+"）的文件，未扣除积分。可使用 /filter 调整要处理的文件"))
+		sendSwapReport(bot, chatID, result)
+		return errors.New("没有符合筛选规则的文件")
+	}
+
+	if len(result.Files) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, unchangedNotice(result.Records)))
+		sendSwapReport(bot, chatID, result)
+		return errors.New("文件未被修改")
+	}
+
+	outFile, err := ioutil.TempFile("", "multi_output_*")
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 创建临时文件失败"))
+		return err
+	}
+	defer os.Remove(outFile.Name())
+	defer outFile.Close()
+
+	if err = zipFiles(dir, names, outFile); err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 创建压缩文件失败: "+err.Error()))
+		return err
+	}
+	if _, err = outFile.Seek(0, io.SeekStart); err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 读取压缩文件失败: "+err.Error()))
+		return err
+	}
+
+	// 开始上传后不再响应取消
+	if opts.Control.Canceled() {
+		return errJobCanceled
+	}
+
+	// 按确认的报价统一计费，发送成功后才扣除冻结的积分
+	price := job.Cost
+	job.setStatus(bot, "⬆️ 正在上传结果...")
+	newName := modifiedFileName(multiFileArchiveName)
+	msg := tgbotapi.NewDocument(chatID, tgbotapi.FileReader{Name: newName, Reader: outFile})
+	msg.Caption = fmt.Sprintf("✅ 美化完成！共 %d 个文件，修改了 %d 个，消耗%.2f积分，剩余积分: %.2f",
+		len(names), len(result.Files), price, balanceAfterCommit(user, job.ID, price))
+	sent, err := bot.Send(msg)
+	if err != nil {
+		log.Printf("发送文件失败: %v", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 发送文件失败，积分已退回，请稍后重试"))
+		return err
+	}
+	job.charged = commitCharge(user, job, price)
+	job.output = saveJobOutput(job.ID, outFile.Name(), newName, sentFileID(sent))
+
+	// 合并结果没有对应的原始文件，不附带补丁清单，需要还原时请单独处理每个文件
+	sendSwapReport(bot, chatID, result)
+	return nil
+}
+
+// 按给定顺序把 dir 中的文件写入 zip
+func zipFiles(dir string, names []string, out io.Writer) error {
+	w := zip.NewWriter(out)
+	for _, name := range names {
+		if err := addZipFile(w, filepath.Join(dir, name), name); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func addZipFile(w *zip.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+	entry, err := w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, f)
+	return err
+}
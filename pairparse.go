@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 代码对解析问题
+type PairIssue struct {
+	Line   int
+	Text   string
+	Reason string
+}
+
+// CSV 表头别名
+var csvHeaderAliases = map[string]string{
+	"original": "original", "from": "original", "a": "original", "原代码": "original",
+	"new": "new", "to": "new", "b": "new", "新代码": "new",
+	"label": "label", "name": "label", "名称": "label",
+	"width": "width", "位宽": "width",
+	"endian": "endian", "字节序": "endian",
+	"strategy": "strategy", "策略": "strategy",
+	"options": "options", "选项": "options",
+}
+
+/******************* 解析代码对文本 *******************/
+// 文本输入与 .txt 文件共用的解析入口，支持：
+//   - 每行一个代码对：[名称] <原代码> <新代码> [选项...] 或 <原代码> -> <新代码> [选项...]
+//   - # 开头的注释（也可写在行尾）和空行
+//   - 带表头的 CSV，例如 original,new,label,strategy
+//   - JSON 数组，元素为 {"original":1234,"new":5678,"label":"..."}、[1234, 5678] 或单行文本格式的字符串
+// 返回全部有效代码对和全部问题行，不会因单行错误中断
+func parsePairs(text string) ([]CodePair, []PairIssue) {
+	text = strings.TrimPrefix(text, "\uFEFF")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "[") && !strings.HasPrefix(trimmed, "[]") && json.Valid([]byte(trimmed)) {
+		return parseJSONPairs(text)
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		content := stripComment(line)
+		if content == "" {
+			continue
+		}
+		if header, ok := parseCSVHeader(content); ok {
+			return parseCSVPairs(lines[i+1:], i+1, header)
+		}
+		break
+	}
+
+	pairs := make([]CodePair, 0)
+	issues := make([]PairIssue, 0)
+	for i, line := range lines {
+		content := stripComment(line)
+		if content == "" {
+			continue
+		}
+		pair, err := parsePairLine(content)
+		if err != nil {
+			issues = append(issues, PairIssue{Line: i + 1, Text: content, Reason: err.Error()})
+			continue
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, issues
+}
+
+// 去掉 # 注释和首尾空白
+func stripComment(line string) string {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
+
+/******************* CSV *******************/
+func parseCSVHeader(line string) ([]string, bool) {
+	if !strings.Contains(line, ",") {
+		return nil, false
+	}
+
+	fields := strings.Split(line, ",")
+	header := make([]string, len(fields))
+	hasOriginal, hasNew := false, false
+	for i, field := range fields {
+		name := csvHeaderAliases[strings.ToLower(strings.TrimSpace(field))]
+		header[i] = name
+		hasOriginal = hasOriginal || name == "original"
+		hasNew = hasNew || name == "new"
+	}
+	return header, hasOriginal && hasNew
+}
+
+// headerLine 为表头所在行号（从1开始），用于换算数据行的行号
+func parseCSVPairs(lines []string, headerLine int, header []string) ([]CodePair, []PairIssue) {
+	pairs := make([]CodePair, 0)
+	issues := make([]PairIssue, 0)
+
+	for i, line := range lines {
+		lineNo := headerLine + i + 1
+		content := stripComment(line)
+		if content == "" {
+			continue
+		}
+
+		r := csv.NewReader(strings.NewReader(content))
+		r.TrimLeadingSpace = true
+		record, err := r.Read()
+		if err != nil && err != io.EOF {
+			issues = append(issues, PairIssue{Line: lineNo, Text: content, Reason: "CSV 格式错误"})
+			continue
+		}
+
+		fields := make(map[string]string)
+		for col, value := range record {
+			if col < len(header) && header[col] != "" {
+				fields[header[col]] = strings.TrimSpace(value)
+			}
+		}
+
+		if fields["original"] == "" || fields["new"] == "" {
+			issues = append(issues, PairIssue{Line: lineNo, Text: content, Reason: "缺少原代码或新代码"})
+			continue
+		}
+
+		pair, err := buildPair(fields["original"], fields["new"], csvOptions(fields), fields["label"])
+		if err != nil {
+			issues = append(issues, PairIssue{Line: lineNo, Text: content, Reason: err.Error()})
+			continue
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, issues
+}
+
+func csvOptions(fields map[string]string) []string {
+	options := strings.Fields(fields["options"])
+	for _, key := range []string{"width", "endian", "strategy"} {
+		value := fields[key]
+		if value == "" {
+			continue
+		}
+		if key == "width" && !strings.HasPrefix(strings.ToLower(value), "u") {
+			value = "u" + value
+		}
+		options = append(options, value)
+	}
+	return options
+}
+
+/******************* JSON *******************/
+type jsonPair struct {
+	Original json.RawMessage `json:"original"`
+	From     json.RawMessage `json:"from"`
+	New      json.RawMessage `json:"new"`
+	To       json.RawMessage `json:"to"`
+	Label    string          `json:"label"`
+	Width    json.RawMessage `json:"width"`
+	Endian   string          `json:"endian"`
+	Strategy string          `json:"strategy"`
+	Options  string          `json:"options"`
+}
+
+func parseJSONPairs(text string) ([]CodePair, []PairIssue) {
+	pairs := make([]CodePair, 0)
+	issues := make([]PairIssue, 0)
+
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	if _, err := dec.Token(); err != nil { // 读取开头的 [
+		return pairs, append(issues, PairIssue{Line: 1, Reason: "JSON 格式错误"})
+	}
+
+	for dec.More() {
+		lineNo := lineAt(text, int(dec.InputOffset()))
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			issues = append(issues, PairIssue{Line: lineNo, Reason: "JSON 格式错误"})
+			break
+		}
+
+		pair, err := jsonElementPair(raw)
+		if err != nil {
+			issues = append(issues, PairIssue{Line: lineNo, Text: compactJSON(raw), Reason: err.Error()})
+			continue
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, issues
+}
+
+func jsonElementPair(raw json.RawMessage) (CodePair, error) {
+	// 数组形式：[原代码, 新代码] 或 [原代码, 新代码, 名称]
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err == nil {
+		if len(list) != 2 && len(list) != 3 {
+			return CodePair{}, errors.New("数组应为 [原代码, 新代码] 或 [原代码, 新代码, 名称]")
+		}
+		label := ""
+		if len(list) == 3 {
+			label = jsonScalar(list[2])
+		}
+		return buildPair(jsonScalar(list[0]), jsonScalar(list[1]), nil, label)
+	}
+
+	// 字符串形式：与单行文本格式相同
+	var line string
+	if err := json.Unmarshal(raw, &line); err == nil {
+		return parsePairLine(line)
+	}
+
+	var item jsonPair
+	if err := json.Unmarshal(raw, &item); err != nil {
+		return CodePair{}, errors.New("元素应为对象、数组或字符串")
+	}
+
+	original := jsonScalar(item.Original)
+	if original == "" {
+		original = jsonScalar(item.From)
+	}
+	newCode := jsonScalar(item.New)
+	if newCode == "" {
+		newCode = jsonScalar(item.To)
+	}
+	if original == "" || newCode == "" {
+		return CodePair{}, errors.New("缺少 original/new 字段")
+	}
+
+	fields := map[string]string{
+		"options":  item.Options,
+		"width":    jsonScalar(item.Width),
+		"endian":   item.Endian,
+		"strategy": item.Strategy,
+	}
+	return buildPair(original, newCode, csvOptions(fields), item.Label)
+}
+
+// 将 JSON 数字或字符串转为文本
+func jsonScalar(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return strings.TrimSpace(s)
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n.String()
+	}
+	return ""
+}
+
+func compactJSON(raw json.RawMessage) string {
+	var sb strings.Builder
+	for _, field := range strings.Fields(string(raw)) {
+		sb.WriteString(field)
+	}
+	return sb.String()
+}
+
+// 返回偏移处下一个有效字符所在的行号
+func lineAt(text string, offset int) int {
+	for offset < len(text) && strings.ContainsRune(" \t\r\n,", rune(text[offset])) {
+		offset++
+	}
+	return strings.Count(text[:offset], "\n") + 1
+}
+
+/******************* 解析结果报告 *******************/
+func formatParseReport(pairs []CodePair, issues []PairIssue) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("✅ 解析成功 %d 个代码对", len(pairs)))
+	if len(issues) == 0 {
+		return sb.String()
+	}
+
+	sb.WriteString(fmt.Sprintf("\n❌ 以下 %d 处有误，已跳过：\n", len(issues)))
+	for _, issue := range issues {
+		if issue.Text != "" {
+			sb.WriteString(fmt.Sprintf("第%d行：%s\n    %s\n", issue.Line, issue.Reason, issue.Text))
+		} else {
+			sb.WriteString(fmt.Sprintf("第%d行：%s\n", issue.Line, issue.Reason))
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
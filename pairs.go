@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 匹配策略：决定替换搜索序列的哪一处出现位置
+const (
+	MatchLast  = "last"  // 最后一处（默认）
+	MatchFirst = "first" // 第一处
+	MatchAll   = "all"   // 全部
+	MatchNth   = "nth"   // 第N处（从1开始计数）
+	MatchRange = "range" // 偏移区间 [Start, End) 内的全部
+)
+
+type MatchStrategy struct {
+	Mode  string `json:"mode"`
+	N     int    `json:"n,omitempty"`
+	Start int    `json:"start,omitempty"`
+	End   int    `json:"end,omitempty"`
+}
+
+// 代码位宽（位），原始十六进制字面量不区分位宽
+var codeWidths = map[string]int{"u16": 16, "u32": 32, "u64": 64}
+
+const (
+	defaultCodeWidth = 32
+	rawHexPrefix     = "hex:" // 原始字节序列，例如 hex:D2040000
+)
+
+// 代码对
+type CodePair struct {
+	Original  string        `json:"original"`   // 用户输入的原代码
+	New       string        `json:"new"`        // 用户输入的新代码
+	HexA      string        `json:"hex_a"`      // 原代码编码后的搜索序列
+	HexB      string        `json:"hex_b"`      // 新代码编码后的搜索序列
+	Width     int           `json:"width"`      // 数值编码位宽
+	BigEndian bool          `json:"big_endian"` // 数值是否按大端编码
+	Strategy  MatchStrategy `json:"strategy"`
+	Label     string        `json:"label,omitempty"` // 可选的代码对名称
+}
+
+func (s MatchStrategy) String() string {
+	switch s.Mode {
+	case MatchNth:
+		return fmt.Sprintf("nth:%d", s.N)
+	case MatchRange:
+		return fmt.Sprintf("range:0x%X-0x%X", s.Start, s.End)
+	case "":
+		return MatchLast
+	default:
+		return s.Mode
+	}
+}
+
+func (p CodePair) String() string {
+	encoding := fmt.Sprintf("u%d ", p.Width)
+	if p.BigEndian {
+		encoding += "be"
+	} else {
+		encoding += "le"
+	}
+	if isRawHex(p.Original) && isRawHex(p.New) {
+		encoding = "hex"
+	}
+	text := fmt.Sprintf("%s ↔ %s (%s, %s)", p.Original, p.New, encoding, p.Strategy)
+	if p.Label != "" {
+		text = "[" + p.Label + "] " + text
+	}
+	return text
+}
+
+/******************* 解析单行代码对 *******************/
+// 每行格式：[名称] <原代码> <新代码> [选项...]，两个代码之间也可以用 -> 连接
+// 代码支持十进制（1234）、0x 开头的十六进制数值（0x4D2）和原始字节序列（hex:D2040000）
+// 选项可任意顺序组合：
+//   位宽 u16、u32、u64（默认 u32）
+//   字节序 le、be（默认 le）
+//   策略 last、first、all、nth:N、range:起始-结束（偏移支持十进制或0x十六进制）
+func parsePairLine(line string) (CodePair, error) {
+	line = strings.TrimSpace(line)
+
+	label := ""
+	if strings.HasPrefix(line, "[") {
+		end := strings.Index(line, "]")
+		if end < 0 {
+			return CodePair{}, fmt.Errorf("名称缺少右括号 ]")
+		}
+		label = strings.TrimSpace(line[1:end])
+		line = line[end+1:]
+	}
+
+	parts := strings.Fields(strings.Replace(line, "->", " ", 1))
+	if len(parts) < 2 {
+		return CodePair{}, fmt.Errorf("格式错误，应为 <原代码> <新代码> [选项...]")
+	}
+	return buildPair(parts[0], parts[1], parts[2:], label)
+}
+
+// 由代码、选项和名称构造代码对
+func buildPair(original, newCode string, options []string, label string) (CodePair, error) {
+	pair := CodePair{
+		Original: original,
+		New:      newCode,
+		Width:    defaultCodeWidth,
+		Strategy: MatchStrategy{Mode: MatchLast},
+		Label:    label,
+	}
+
+	seenWidth, seenEndian, seenStrategy := false, false, false
+	for _, option := range options {
+		lower := strings.ToLower(option)
+		switch {
+		case codeWidths[lower] != 0:
+			if seenWidth {
+				return CodePair{}, fmt.Errorf("重复指定位宽")
+			}
+			pair.Width, seenWidth = codeWidths[lower], true
+		case lower == "le" || lower == "be":
+			if seenEndian {
+				return CodePair{}, fmt.Errorf("重复指定字节序")
+			}
+			pair.BigEndian, seenEndian = lower == "be", true
+		default:
+			if seenStrategy {
+				return CodePair{}, fmt.Errorf("无法识别的选项: %s", option)
+			}
+			strategy, err := parseMatchStrategy(option)
+			if err != nil {
+				return CodePair{}, err
+			}
+			pair.Strategy, seenStrategy = strategy, true
+		}
+	}
+
+	if err := encodePair(&pair); err != nil {
+		return CodePair{}, err
+	}
+	return pair, nil
+}
+
+// 按位宽和字节序计算代码对的搜索序列
+func encodePair(pair *CodePair) error {
+	var err error
+	if pair.HexA, err = encodeCode(pair.Original, pair.Width, pair.BigEndian); err != nil {
+		return err
+	}
+	if pair.HexB, err = encodeCode(pair.New, pair.Width, pair.BigEndian); err != nil {
+		return err
+	}
+	if len(pair.HexA) != len(pair.HexB) {
+		return fmt.Errorf("两个代码的字节长度不一致（%d 与 %d 字节）", len(pair.HexA)/2, len(pair.HexB)/2)
+	}
+	return nil
+}
+
+func isRawHex(text string) bool {
+	return strings.HasPrefix(strings.ToLower(text), rawHexPrefix)
+}
+
+/******************* 代码编码 *******************/
+// 将代码编码为十六进制搜索序列；原始字节序列原样返回
+func encodeCode(text string, width int, bigEndian bool) (string, error) {
+	if isRawHex(text) {
+		raw := text[len(rawHexPrefix):]
+		b, err := hex.DecodeString(raw)
+		if err != nil || len(b) == 0 {
+			return "", fmt.Errorf("无效的十六进制序列: %s", text)
+		}
+		return strings.ToUpper(hex.EncodeToString(b)), nil
+	}
+
+	if strings.HasPrefix(text, "-") {
+		return "", fmt.Errorf("代码不能为负数: %s", text)
+	}
+	// 默认十进制（前导 0 不视为八进制），只有 0x 前缀按十六进制解析
+	digits, base := text, 10
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		digits, base = text[2:], 16
+	}
+	value, err := strconv.ParseUint(digits, base, width)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return "", fmt.Errorf("代码 %s 超出 %d 位范围", text, width)
+		}
+		return "", fmt.Errorf("无效的代码: %s", text)
+	}
+	return encodeValue(value, width, bigEndian), nil
+}
+
+func encodeValue(value uint64, width int, bigEndian bool) string {
+	b := make([]byte, width/8)
+	for i := range b {
+		b[i] = byte(value >> (8 * i))
+	}
+	if bigEndian {
+		for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+			b[i], b[j] = b[j], b[i]
+		}
+	}
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+func parseMatchStrategy(text string) (MatchStrategy, error) {
+	mode, arg, _ := strings.Cut(strings.ToLower(text), ":")
+	switch mode {
+	case MatchLast, MatchFirst, MatchAll:
+		if arg != "" {
+			return MatchStrategy{}, fmt.Errorf("策略 %s 不需要参数", mode)
+		}
+		return MatchStrategy{Mode: mode}, nil
+
+	case MatchNth:
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return MatchStrategy{}, fmt.Errorf("无效的 nth 策略，应为 nth:N（N≥1）")
+		}
+		return MatchStrategy{Mode: MatchNth, N: n}, nil
+
+	case MatchRange:
+		startText, endText, ok := strings.Cut(arg, "-")
+		start, err1 := parseOffset(startText)
+		end, err2 := parseOffset(endText)
+		if !ok || err1 != nil || err2 != nil || start >= end {
+			return MatchStrategy{}, fmt.Errorf("无效的 range 策略，应为 range:起始-结束")
+		}
+		return MatchStrategy{Mode: MatchRange, Start: start, End: end}, nil
+	}
+	return MatchStrategy{}, fmt.Errorf("未知的匹配策略: %s", text)
+}
+
+func parseOffset(text string) (int, error) {
+	v, err := strconv.ParseInt(text, 0, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("无效的偏移: %s", text)
+	}
+	return int(v), nil
+}
+
+/******************* 查找匹配位置 *******************/
+// 返回按策略选中的全部偏移，未找到时返回空切片
+func findOccurrences(content, seq []byte, strategy MatchStrategy) []int {
+	if len(seq) == 0 {
+		return nil
+	}
+
+	switch strategy.Mode {
+	case MatchFirst:
+		if i := bytes.Index(content, seq); i >= 0 {
+			return []int{i}
+		}
+		return nil
+
+	case MatchAll, MatchNth, MatchRange:
+		offsets := make([]int, 0)
+		for pos := 0; pos+len(seq) <= len(content); {
+			i := bytes.Index(content[pos:], seq)
+			if i < 0 {
+				break
+			}
+			offset := pos + i
+			pos = offset + len(seq)
+
+			switch strategy.Mode {
+			case MatchNth:
+				offsets = append(offsets, offset)
+				if len(offsets) == strategy.N {
+					return []int{offset}
+				}
+			case MatchRange:
+				if offset >= strategy.End {
+					return offsets
+				}
+				if offset >= strategy.Start {
+					offsets = append(offsets, offset)
+				}
+			default:
+				offsets = append(offsets, offset)
+			}
+		}
+		if strategy.Mode == MatchNth {
+			return nil
+		}
+		return offsets
+
+	default:
+		if i := bytes.LastIndex(content, seq); i >= 0 {
+			return []int{i}
+		}
+		return nil
+	}
+}
+
+/******************* 格式化替换结果 *******************/
+func formatOffsets(offsets []int) string {
+	parts := make([]string, 0, len(offsets))
+	for _, offset := range offsets {
+		parts = append(parts, fmt.Sprintf("0x%X", offset))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// 单个文件中某个代码对的处理结果
+type SwapRecord struct {
+	File     string
+	Pair     CodePair
+	OffsetsA []int
+	OffsetsB []int
+	Found    bool
+	Error    string
+}
+
+func missingReason(pair CodePair, offsetsA, offsetsB []int) string {
+	switch {
+	case len(offsetsA) == 0 && len(offsetsB) == 0:
+		return fmt.Sprintf("未找到 %s 和 %s", pair.Original, pair.New)
+	case len(offsetsA) == 0:
+		return fmt.Sprintf("未找到 %s", pair.Original)
+	default:
+		return fmt.Sprintf("未找到 %s", pair.New)
+	}
+}
+
+func formatSwapReport(records []SwapRecord) string {
+	found, missing := 0, 0
+	for _, r := range records {
+		if r.Found {
+			found++
+		} else {
+			missing++
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📋 处理报告：成功 %d 项，未找到 %d 项\n", found, missing))
+	lastFile := ""
+	for _, r := range records {
+		if r.File != lastFile {
+			sb.WriteString(fmt.Sprintf("📄 %s\n", r.File))
+			lastFile = r.File
+		}
+		if !r.Found {
+			sb.WriteString(fmt.Sprintf("  ❌ %s\n     %s\n", r.Pair, r.Error))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("  ✅ %s\n     %s @ %s\n     %s @ %s\n",
+			r.Pair, r.Pair.Original, formatOffsets(r.OffsetsA), r.Pair.New, formatOffsets(r.OffsetsB)))
+	}
+	return sb.String()
+}
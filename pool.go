@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+)
+
+// 并发处理文件的协程数上限
+var (
+	jobWorkerLimit    = 4                    // 单个任务同时处理的文件数
+	globalWorkerLimit = runtime.NumCPU() * 2 // 所有任务同时处理的文件总数
+)
+
+// 所有任务共享的处理槽位
+var globalWorkers = make(chan struct{}, globalWorkerLimit)
+
+/******************* 有界协程池 *******************/
+// 并发执行 task(0) … task(n-1)，同时运行的数量受单任务和全局上限约束
+// 全部执行完后按下标顺序合并返回错误，结果由调用方按下标保存以保证输出顺序固定
+func runBounded(n int, task func(i int) error) error {
+	errs := make([]error, n)
+	jobSlots := make(chan struct{}, jobWorkerLimit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		// 先占单任务槽位再占全局槽位，顺序固定避免互相等待
+		jobSlots <- struct{}{}
+		globalWorkers <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				// 协程中的异常不会被任务的 recover 捕获，转为该文件的错误，避免整个进程退出
+				if r := recover(); r != nil {
+					log.Printf("处理文件异常: %v", r)
+					errs[i] = fmt.Errorf("内部错误: %v", r)
+				}
+				<-globalWorkers
+				<-jobSlots
+				wg.Done()
+			}()
+			errs[i] = task(i)
+		}(i)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// 并发处理时每个文件可整体读入内存的大小上限，保证同时处理的文件总占用不超过任务内存预算
+func fileMemoryLimit() int64 {
+	workers := int64(jobWorkerLimit)
+	if workers < 1 {
+		workers = 1
+	}
+	return jobMemoryBudget / 2 / workers
+}
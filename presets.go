@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 用户保存的代码对预设
+type Preset struct {
+	Name      string     `json:"name"`
+	Pairs     []CodePair `json:"pairs"`
+	Filter    FileFilter `json:"filter"` // 目标文件规则，为空时沿用会话中的规则
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+const (
+	maxPresetsPerUser = 20
+	maxPresetNameLen  = 48 // 字节数，受内嵌按钮回调数据 64 字节的限制
+	presetButtonLimit = 6  // 开始美化时最多展示的预设按钮数
+)
+
+var (
+	presetsFile = "presets.json"
+	presets     = make(map[int64]map[string]*Preset) // 用户ID -> 名称 -> 预设
+	presetsMu   sync.Mutex
+)
+
+/******************* 预设命令处理 *******************/
+// /preset save <名称>      保存当前会话的代码对，或同一条消息中名称后面换行附带的代码对
+// /preset list             列出全部预设
+// /preset show <名称>      查看预设内容
+// /preset delete <名称>    删除预设
+// /preset use <名称>       将预设载入当前美化会话
+func handlePresetCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message, user *User) {
+	chatID := message.Chat.ID
+	firstLine, body, _ := strings.Cut(message.CommandArguments(), "\n")
+	args := strings.Fields(firstLine)
+
+	usage := "用法：\n/preset save <名称>\n/preset list\n/preset show <名称>\n/preset delete <名称>\n/preset use <名称>"
+	if len(args) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, usage))
+		return
+	}
+
+	action := strings.ToLower(args[0])
+	if action == "list" {
+		listPresets(bot, chatID, user)
+		return
+	}
+
+	if len(args) < 2 {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 请指定预设名称\n"+usage))
+		return
+	}
+	name := args[1]
+
+	switch action {
+	case "save":
+		savePreset(bot, chatID, user, name, body)
+	case "show":
+		showPreset(bot, chatID, user, name)
+	case "delete":
+		deletePreset(bot, chatID, user, name)
+	case "use":
+		usePreset(bot, chatID, user, name)
+	default:
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 未知的预设操作\n"+usage))
+	}
+}
+
+func savePreset(bot *tgbotapi.BotAPI, chatID int64, user *User, name, body string) {
+	if len(name) > maxPresetNameLen {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ 预设名称过长（最多%d字节，约%d个汉字）", maxPresetNameLen, maxPresetNameLen/3)))
+		return
+	}
+
+	// 优先使用消息中附带的代码对，否则使用当前会话中的代码对；目标文件规则取自当前会话
+	var pairs []CodePair
+	report := ""
+	if strings.TrimSpace(body) != "" {
+		var issues []PairIssue
+		pairs, issues = parsePairs(body)
+		report = formatParseReport(pairs, issues) + "\n\n"
+	} else if data, ok := processingUsers.Load(user.ID); ok {
+		pairs, _ = data.(map[string]interface{})["codes"].([]CodePair)
+	}
+	filter := sessionFilter(user.ID)
+	if len(pairs) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, report+"❌ 没有可保存的代码对。请在名称后换行附上代码对，或先在美化会话中输入代码对"))
+		return
+	}
+
+	presetsMu.Lock()
+	userPresets, ok := presets[user.ID]
+	if !ok {
+		userPresets = make(map[string]*Preset)
+		presets[user.ID] = userPresets
+	}
+	preset, exists := userPresets[name]
+	if !exists && len(userPresets) >= maxPresetsPerUser {
+		presetsMu.Unlock()
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ 最多只能保存%d个预设，请先删除不需要的预设", maxPresetsPerUser)))
+		return
+	}
+	now := time.Now()
+	if !exists {
+		preset = &Preset{Name: name, CreatedAt: now}
+		userPresets[name] = preset
+	}
+	preset.Pairs = pairs
+	preset.Filter = filter
+	preset.UpdatedAt = now
+	presetsMu.Unlock()
+	savePresets()
+
+	verb := "已保存"
+	if exists {
+		verb = "已更新"
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("%s✅ %s预设「%s」，共%d个代码对", report, verb, name, len(pairs))))
+}
+
+func listPresets(bot *tgbotapi.BotAPI, chatID int64, user *User) {
+	list := userPresetList(user.ID)
+	if len(list) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "📦 您还没有保存任何预设。使用 /preset save <名称> 保存"))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("📦 我的预设：\n")
+	for _, p := range list {
+		sb.WriteString(fmt.Sprintf("▫️ %s - %d个代码对（更新于 %s）\n", p.Name, len(p.Pairs), p.UpdatedAt.Format("2006-01-02")))
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, sb.String()))
+}
+
+func showPreset(bot *tgbotapi.BotAPI, chatID int64, user *User, name string) {
+	preset := findPreset(user.ID, name)
+	if preset == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 预设不存在"))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📦 预设「%s」（%d个代码对）：\n", preset.Name, len(preset.Pairs)))
+	if !preset.Filter.IsZero() {
+		sb.WriteString("📂 目标文件：" + preset.Filter.String() + "\n")
+	}
+	for _, pair := range preset.Pairs {
+		sb.WriteString("▫️ " + pair.String() + "\n")
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, sb.String()))
+}
+
+func deletePreset(bot *tgbotapi.BotAPI, chatID int64, user *User, name string) {
+	presetsMu.Lock()
+	_, exists := presets[user.ID][name]
+	delete(presets[user.ID], name)
+	presetsMu.Unlock()
+
+	if !exists {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 预设不存在"))
+		return
+	}
+	savePresets()
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ 已删除预设「%s」", name)))
+}
+
+/******************* 载入预设 *******************/
+func usePreset(bot *tgbotapi.BotAPI, chatID int64, user *User, name string) {
+	preset := findPreset(user.ID, name)
+	if preset == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 预设不存在"))
+		return
+	}
+
+	// 没有进行中的会话时自动开始美化
+	if _, ok := processingUsers.Load(user.ID); !ok {
+		if !startBeautifySession(bot, user, chatID) {
+			return
+		}
+	}
+	loadPairsIntoSession(bot, chatID, user, preset.Pairs, preset.Filter, fmt.Sprintf("预设「%s」", preset.Name))
+}
+
+// 将代码对载入当前会话，替换会话中已有的代码对；预设带有目标文件规则时一并替换
+func loadPairsIntoSession(bot *tgbotapi.BotAPI, chatID int64, user *User, pairs []CodePair, filter FileFilter, source string) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理会话已过期"))
+		return
+	}
+
+	processData := data.(map[string]interface{})
+	processData["codes"] = append([]CodePair(nil), pairs...)
+	if !filter.IsZero() {
+		opts := sessionOptions(processData)
+		opts.Filter = filter
+		processData["options"] = opts
+	}
+	delete(processData, "cost") // 恢复默认计费，共享预设会在载入后重新设置
+	delete(processData, "library_preset")
+	processData["last_activity"] = time.Now()
+	processingUsers.Store(user.ID, processData)
+
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ 已载入%s，共%d个代码对。请发送要处理的文件，或继续输入代码对。", source, len(pairs))))
+	sendPairAnalysis(bot, chatID, pairs, sessionOptions(processData))
+}
+
+func findPreset(userID int64, name string) *Preset {
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+	return presets[userID][name]
+}
+
+// 按最近更新时间排序的预设列表
+func userPresetList(userID int64) []*Preset {
+	presetsMu.Lock()
+	list := make([]*Preset, 0, len(presets[userID]))
+	for _, p := range presets[userID] {
+		list = append(list, p)
+	}
+	presetsMu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].UpdatedAt.After(list[j].UpdatedAt) })
+	return list
+}
+
+/******************* 预设选择按钮 *******************/
+func presetButtonRows(userID int64) [][]tgbotapi.InlineKeyboardButton {
+	list := userPresetList(userID)
+	if len(list) > presetButtonLimit {
+		list = list[:presetButtonLimit]
+	}
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	for i := 0; i < len(list); i += 2 {
+		row := []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("📦 "+list[i].Name, "preset_use:"+list[i].Name),
+		}
+		if i+1 < len(list) {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData("📦 "+list[i+1].Name, "preset_use:"+list[i+1].Name))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+/******************* 加载/保存 预设 *******************/
+func loadPresets() {
+	file, err := ioutil.ReadFile(presetsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取预设文件失败: %v", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(file, &presets); err != nil {
+		log.Printf("解析预设文件失败: %v", err)
+	}
+}
+
+func savePresets() {
+	presetsMu.Lock()
+	data, err := json.MarshalIndent(presets, "", "  ")
+	presetsMu.Unlock()
+	if err != nil {
+		log.Printf("序列化预设数据失败: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(presetsFile, data, 0644); err != nil {
+		log.Printf("保存预设数据失败: %v", err)
+	}
+}
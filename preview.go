@@ -0,0 +1,421 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 预览时缓存的已下载文件，正式执行时复用
+type PreviewFile struct {
+	Path         string
+	FileName     string
+	FileID       string
+	FileUniqueID string
+	Size         int64
+}
+
+// 单个文件中某个代码对的预览结果
+type PreviewRecord struct {
+	File     string
+	Pair     CodePair
+	CountA   int
+	CountB   int
+	OffsetsA []int
+	OffsetsB []int
+}
+
+var previewCacheDir = filepath.Join(os.TempDir(), "tgbot_preview")
+
+/******************* 切换预览模式 *******************/
+func setPreviewMode(bot *tgbotapi.BotAPI, user *User, chatID int64, enabled bool) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 当前没有进行中的美化任务，请先点击「自动美化」"))
+		return
+	}
+
+	processData := data.(map[string]interface{})
+	processData["last_activity"] = time.Now()
+	opts := sessionOptions(processData)
+	opts.Preview = enabled
+	processData["options"] = opts
+	processingUsers.Store(user.ID, processData)
+
+	if enabled {
+		bot.Send(tgbotapi.NewMessage(chatID, "🔍 已开启预览模式：发送 .dat 文件或压缩包将只查找代码对，不生成文件、不扣积分"))
+	} else {
+		bot.Send(tgbotapi.NewMessage(chatID, "✅ 已关闭预览模式，发送文件将正式执行美化"))
+	}
+}
+
+func sessionPreviewMode(userID int64) bool {
+	data, ok := processingUsers.Load(userID)
+	if !ok {
+		return false
+	}
+	return sessionOptions(data.(map[string]interface{})).Preview
+}
+
+func sessionPreviewFile(userID int64) *PreviewFile {
+	data, ok := processingUsers.Load(userID)
+	if !ok {
+		return nil
+	}
+	cached, _ := data.(map[string]interface{})["preview_file"].(*PreviewFile)
+	return cached
+}
+
+// 删除会话中缓存的预览文件
+func clearPreviewFile(processData map[string]interface{}) {
+	if cached, ok := processData["preview_file"].(*PreviewFile); ok {
+		os.Remove(cached.Path)
+		delete(processData, "preview_file")
+	}
+}
+
+/******************* 预览美化结果 *******************/
+func previewFile(bot *tgbotapi.BotAPI, user *User, chatID int64, filePath string, document *tgbotapi.Document) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理会话已过期"))
+		return
+	}
+
+	processData := data.(map[string]interface{})
+	codes, ok := processData["codes"].([]CodePair)
+	if !ok || len(codes) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 请先发送代码对再发送文件"))
+		return
+	}
+
+	var records []PreviewRecord
+	var err error
+	if format := archiveFormat(document.FileName); format == formatZip {
+		records, err = previewZip(filePath, codes, sessionOptions(processData).Filter)
+	} else if format != "" {
+		records, err = previewArchive(format, filePath, document.FileName, codes, sessionOptions(processData).Filter)
+	} else {
+		records, err = previewPath(filePath, document.FileName, codes)
+	}
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 预览失败: "+err.Error()))
+		return
+	}
+
+	// 缓存已下载的文件，正式执行时无需重新下载
+	cached, err := cacheDownloadedFile(user.ID, filePath, document)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 缓存文件失败: "+err.Error()))
+		return
+	}
+	clearOldPreview(processData, cached.Path)
+	processData["preview_file"] = cached
+	processData["last_activity"] = time.Now()
+	processingUsers.Store(user.ID, processData)
+
+	sendPreviewReport(bot, chatID, records)
+
+	msg := tgbotapi.NewMessage(chatID, "🔍 以上为预览结果，未生成文件、未扣除积分。\n确认无误后可直接正式执行，无需重新发送文件；也可以继续添加代码对后重新发送文件预览。")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("▶️ 正式执行", "preview_run"),
+			tgbotapi.NewInlineKeyboardButtonData("关闭预览", "preview_off"),
+		),
+	)
+	bot.Send(msg)
+}
+
+// 保留当前缓存文件，删除其余旧缓存
+func clearOldPreview(processData map[string]interface{}, keepPath string) {
+	if old, ok := processData["preview_file"].(*PreviewFile); ok && old.Path != keepPath {
+		os.Remove(old.Path)
+	}
+}
+
+func cacheDownloadedFile(userID int64, filePath string, document *tgbotapi.Document) (*PreviewFile, error) {
+	if err := os.MkdirAll(previewCacheDir, 0755); err != nil {
+		return nil, err
+	}
+
+	// 每次预览使用独立的文件，已加入队列的任务仍持有之前的副本
+	out, err := ioutil.TempFile(previewCacheDir, fmt.Sprintf("%d_*%s", userID, filepath.Ext(document.FileName)))
+	if err != nil {
+		return nil, err
+	}
+	cachePath := out.Name()
+	out.Close()
+	if err := copyFile(filePath, cachePath); err != nil {
+		os.Remove(cachePath)
+		return nil, err
+	}
+
+	return &PreviewFile{Path: cachePath, FileName: document.FileName, FileID: document.FileID, FileUniqueID: document.FileUniqueID, Size: int64(document.FileSize)}, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+/******************* 正式执行已预览的文件 *******************/
+func runPreviewedFile(bot *tgbotapi.BotAPI, user *User, chatID int64) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理会话已过期"))
+		return
+	}
+
+	processData := data.(map[string]interface{})
+	cached, ok := processData["preview_file"].(*PreviewFile)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 没有可执行的预览文件，请重新发送文件"))
+		return
+	}
+	// 已下载的副本交给任务处理，任务结束后删除
+	quoteSessionJob(bot, user, chatID, []JobFile{{
+		FileID:       cached.FileID,
+		FileUniqueID: cached.FileUniqueID,
+		Name:         cached.FileName,
+		Size:         cached.Size,
+		Path:         cached.Path,
+	}}, false)
+}
+
+/******************* 查找代码对（不修改文件） *******************/
+func previewCodePairs(content []byte, file string, codes []CodePair) []PreviewRecord {
+	all := MatchStrategy{Mode: MatchAll}
+	records := make([]PreviewRecord, 0, len(codes))
+	for _, pair := range codes {
+		seqA, _ := hex.DecodeString(pair.HexA)
+		seqB, _ := hex.DecodeString(pair.HexB)
+		records = append(records, PreviewRecord{
+			File:     file,
+			Pair:     pair,
+			CountA:   len(findOccurrences(content, seqA, all)),
+			CountB:   len(findOccurrences(content, seqB, all)),
+			OffsetsA: findOccurrences(content, seqA, pair.Strategy),
+			OffsetsB: findOccurrences(content, seqB, pair.Strategy),
+		})
+	}
+	return records
+}
+
+// 按内存预算预览磁盘上的文件：不超过预算时读入内存，否则分块扫描
+func previewPath(path, file string, codes []CodePair) ([]PreviewRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() <= fileMemoryLimit() {
+		content, err := io.ReadAll(f)
+		if err != nil {
+			return nil, err
+		}
+		return previewCodePairs(content, file, codes), nil
+	}
+	return previewCodePairsFile(f, info.Size(), file, codes)
+}
+
+// 与 previewCodePairs 结果一致，分块读取文件查找
+func previewCodePairsFile(r io.ReaderAt, size int64, file string, codes []CodePair) ([]PreviewRecord, error) {
+	all := MatchStrategy{Mode: MatchAll}
+	records := make([]PreviewRecord, 0, len(codes))
+	for _, pair := range codes {
+		seqA, _ := hex.DecodeString(pair.HexA)
+		seqB, _ := hex.DecodeString(pair.HexB)
+		countA, err1 := scanOccurrences(r, size, seqA, all)
+		countB, err2 := scanOccurrences(r, size, seqB, all)
+		offsetsA, err3 := scanOccurrences(r, size, seqA, pair.Strategy)
+		offsetsB, err4 := scanOccurrences(r, size, seqB, pair.Strategy)
+		if err := errors.Join(err1, err2, err3, err4); err != nil {
+			return nil, err
+		}
+		records = append(records, PreviewRecord{
+			File:     file,
+			Pair:     pair,
+			CountA:   len(countA),
+			CountB:   len(countB),
+			OffsetsA: offsetsA,
+			OffsetsB: offsetsB,
+		})
+	}
+	return records, nil
+}
+
+func previewZip(zipPath string, codes []CodePair, filter FileFilter) ([]PreviewRecord, error) {
+	r, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("打开压缩包失败: %w", err)
+	}
+	defer r.Close()
+
+	if err := checkZipArchive(r.File, newExtractBudget()); err != nil {
+		return nil, err
+	}
+
+	// 超出内存预算的条目解压到临时文件后分块扫描
+	tempDir, err := ioutil.TempDir("", "preview_zip_*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	records := make([]PreviewRecord, 0)
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !filter.Match(f.Name) {
+			continue
+		}
+
+		entryRecords, err := previewZipEntry(f, tempDir, codes)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, entryRecords...)
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("压缩包中没有符合规则的文件")
+	}
+	return records, nil
+}
+
+func previewZipEntry(f *zip.File, tempDir string, codes []CodePair) ([]PreviewRecord, error) {
+	if int64(f.UncompressedSize64) > fileMemoryLimit() {
+		target := filepath.Join(tempDir, "entry")
+		defer os.Remove(target)
+		if _, err := extractZipEntry(f, target, int64(f.UncompressedSize64)); err != nil {
+			return nil, err
+		}
+		return previewPath(target, f.Name, codes)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(io.LimitReader(rc, int64(f.UncompressedSize64)))
+	if err != nil {
+		return nil, err
+	}
+	return previewCodePairs(content, f.Name, codes), nil
+}
+
+// 其他格式的压缩包解压到临时目录后逐个预览 .dat 文件
+func previewArchive(format, archivePath, name string, codes []CodePair, filter FileFilter) ([]PreviewRecord, error) {
+	workDir, err := ioutil.TempDir("", "preview_*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	if err := extractArchive(format, archivePath, name, workDir, newExtractBudget()); err != nil {
+		return nil, fmt.Errorf("解压失败: %w", err)
+	}
+
+	records := make([]PreviewRecord, 0)
+	err = filepath.Walk(workDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, _ := filepath.Rel(workDir, path)
+		relPath = filepath.ToSlash(relPath)
+		if !filter.Match(relPath) {
+			return nil
+		}
+		fileRecords, err := previewPath(path, relPath, codes)
+		if err != nil {
+			return err
+		}
+		records = append(records, fileRecords...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("压缩包中没有符合规则的文件")
+	}
+	return records, nil
+}
+
+/******************* 发送预览报告 *******************/
+func formatPreviewReport(records []PreviewRecord) string {
+	matched, missing := 0, 0
+	for _, r := range records {
+		if len(r.OffsetsA) > 0 && len(r.OffsetsB) > 0 {
+			matched++
+		} else {
+			missing++
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🔍 预览报告：可生效 %d 项，未找到 %d 项\n", matched, missing))
+	lastFile := ""
+	for _, r := range records {
+		if r.File != lastFile {
+			sb.WriteString(fmt.Sprintf("📄 %s\n", r.File))
+			lastFile = r.File
+		}
+
+		mark := "✅"
+		if len(r.OffsetsA) == 0 || len(r.OffsetsB) == 0 {
+			mark = "❌"
+		}
+		sb.WriteString(fmt.Sprintf("  %s %s\n", mark, r.Pair))
+		sb.WriteString(fmt.Sprintf("     %s：共 %d 处，将替换 %s\n", r.Pair.Original, r.CountA, previewOffsets(r.OffsetsA)))
+		sb.WriteString(fmt.Sprintf("     %s：共 %d 处，将替换 %s\n", r.Pair.New, r.CountB, previewOffsets(r.OffsetsB)))
+	}
+	sb.WriteString("\n注：预览基于原始文件，多个代码对涉及相同代码时正式执行结果可能不同")
+	return sb.String()
+}
+
+func previewOffsets(offsets []int) string {
+	if len(offsets) == 0 {
+		return "无"
+	}
+	return formatOffsets(offsets)
+}
+
+func sendPreviewReport(bot *tgbotapi.BotAPI, chatID int64, records []PreviewRecord) {
+	report := formatPreviewReport(records)
+	if len(report) <= 4000 {
+		bot.Send(tgbotapi.NewMessage(chatID, report))
+		return
+	}
+
+	msg := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: "preview_report.txt", Bytes: []byte(report)})
+	msg.Caption = "🔍 预览报告"
+	bot.Send(msg)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var pricingFile = "pricing.json"
+
+// 美化任务计费规则：基础价格 + 第一个之后每个文件 + 每个代码对 + 每 MB 输入，
+// 总价不超过基础价格的 MaxRate 倍（为 0 时不封顶）。使用共享预设时基础价格为预设价格，封顶仍不低于默认基础价格的 MaxRate 倍
+type PricingRules struct {
+	Base      float64 `json:"base"`
+	PerFile   float64 `json:"per_file"`
+	PerPair   float64 `json:"per_pair"`
+	PerMB     float64 `json:"per_mb"`
+	MaxRate   float64 `json:"max_rate"`
+	FreeDaily int     `json:"free_daily"` // 每人每天免费任务次数
+}
+
+type pricingData struct {
+	Rules    PricingRules             `json:"rules"`
+	FreeUsed map[string]map[int64]int `json:"free_used"` // 日期 -> 用户 -> 当天已用的免费次数
+}
+
+var (
+	pricing = pricingData{
+		Rules:    PricingRules{Base: 1, PerFile: 0.5, MaxRate: 5},
+		FreeUsed: make(map[string]map[int64]int),
+	}
+	pricingMu sync.Mutex
+)
+
+func pricingRules() PricingRules {
+	pricingMu.Lock()
+	defer pricingMu.Unlock()
+	return pricing.Rules
+}
+
+// 任务报价
+type PriceQuote struct {
+	Base     float64
+	Files    float64
+	Pairs    float64
+	Size     float64
+	Total    float64
+	Free     bool // 使用当天的免费次数，不扣积分
+	FreeLeft int  // 报价时剩余的免费次数
+}
+
+/******************* 计算报价 *******************/
+func quotePrice(userID int64, base float64, files []JobFile, pairs int) *PriceQuote {
+	rules := pricingRules()
+	size := int64(0)
+	for _, f := range files {
+		size += f.Size
+	}
+
+	q := &PriceQuote{
+		Base:  base,
+		Files: rules.PerFile * float64(len(files)-1),
+		Pairs: rules.PerPair * float64(pairs),
+		Size:  rules.PerMB * float64(size) / 1024 / 1024,
+	}
+	q.Total = q.Base + q.Files + q.Pairs + q.Size
+	// 封顶按预设价格和默认基础价格中较高者计算，避免低价预设把附加费用一并抹去
+	if limit := math.Max(base, rules.Base) * rules.MaxRate; rules.MaxRate > 0 && q.Total > limit {
+		q.Total = limit
+	}
+	q.Total = math.Round(q.Total*100) / 100
+
+	q.FreeLeft = freeJobsLeft(userID)
+	q.Free = q.FreeLeft > 0
+	return q
+}
+
+// 实际需要冻结和扣除的积分
+func (q *PriceQuote) Charge() float64 {
+	if q.Free {
+		return 0
+	}
+	return q.Total
+}
+
+func (q *PriceQuote) String() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("基础价格 %.2f", q.Base))
+	if q.Files > 0 {
+		sb.WriteString(fmt.Sprintf(" + 文件 %.2f", q.Files))
+	}
+	if q.Pairs > 0 {
+		sb.WriteString(fmt.Sprintf(" + 代码对 %.2f", q.Pairs))
+	}
+	if q.Size > 0 {
+		sb.WriteString(fmt.Sprintf(" + 大小 %.2f", q.Size))
+	}
+	sb.WriteString(fmt.Sprintf("\n合计：%.2f 积分", q.Total))
+	if q.Free {
+		sb.WriteString(fmt.Sprintf("\n🎁 使用今日免费次数（剩余 %d 次），本次不扣积分", q.FreeLeft))
+	}
+	return sb.String()
+}
+
+/******************* 每日免费次数 *******************/
+func freeJobsLeft(userID int64) int {
+	mu.Lock()
+	defer mu.Unlock()
+	return freeJobsLeftLocked(userID)
+}
+
+// 当天剩余的免费次数，冻结中的免费任务也计入已用，调用方需持有 mu
+func freeJobsLeftLocked(userID int64) int {
+	pricingMu.Lock()
+	left := pricing.Rules.FreeDaily - pricing.FreeUsed[today()][userID]
+	pricingMu.Unlock()
+
+	for _, r := range reservations {
+		if r.UserID == userID && r.Free {
+			left--
+		}
+	}
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+// 免费任务结算后计入当天的次数，只保留当天的记录
+func recordFreeUse(userID int64) {
+	pricingMu.Lock()
+	day := today()
+	for d := range pricing.FreeUsed {
+		if d != day {
+			delete(pricing.FreeUsed, d)
+		}
+	}
+	if pricing.FreeUsed[day] == nil {
+		pricing.FreeUsed[day] = make(map[int64]int)
+	}
+	pricing.FreeUsed[day][userID]++
+	pricingMu.Unlock()
+	savePricing()
+}
+
+func today() string {
+	return time.Now().Format("2006-01-02")
+}
+
+/******************* 报价确认 *******************/
+// 会话中等待用户确认报价的任务
+type pendingQuote struct {
+	Files     []JobFile
+	Multi     bool
+	Total     float64
+	Free      bool
+	MessageID int
+}
+
+// 发送报价并等待用户确认，确认后任务才加入队列
+func quoteSessionJob(bot *tgbotapi.BotAPI, user *User, chatID int64, files []JobFile, multi bool) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理会话已过期"))
+		return
+	}
+	processData := data.(map[string]interface{})
+	codes, ok := processData["codes"].([]CodePair)
+	if !ok || len(codes) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 未找到有效的代码对，请先发送代码对"))
+		return
+	}
+
+	q := quotePrice(user.ID, sessionCost(processData), files, len(codes))
+	size := int64(0)
+	for _, f := range files {
+		size += f.Size
+	}
+	text := fmt.Sprintf("💰 任务报价\n文件：%d 个，共 %.2f MB\n代码对：%d 个\n%s\n当前可用积分：%.2f",
+		len(files), float64(size)/1024/1024, len(codes), q, availablePoints(user))
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ 确认执行", "quote_confirm"),
+			tgbotapi.NewInlineKeyboardButtonData("❌ 取消", "quote_cancel"),
+		),
+	)
+	sent, err := bot.Send(msg)
+	if err != nil {
+		return
+	}
+
+	processData["quote"] = &pendingQuote{Files: files, Multi: multi, Total: q.Total, Free: q.Free, MessageID: sent.MessageID}
+	processData["last_activity"] = time.Now()
+	processingUsers.Store(user.ID, processData)
+}
+
+// 确认报价：按当前会话重新计算，价格变化时重新报价，否则冻结积分并加入队列
+func confirmQuote(bot *tgbotapi.BotAPI, user *User, chatID int64) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理会话已过期"))
+		return
+	}
+	processData := data.(map[string]interface{})
+	quote, ok := processData["quote"].(*pendingQuote)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 没有待确认的报价，请重新发送文件"))
+		return
+	}
+	delete(processData, "quote")
+
+	codes, _ := processData["codes"].([]CodePair)
+	q := quotePrice(user.ID, sessionCost(processData), quote.Files, len(codes))
+	if q.Total != quote.Total || q.Free != quote.Free {
+		bot.Send(tgbotapi.NewEditMessageText(chatID, quote.MessageID, "⚠️ 代码对或计费规则已变化，报价已失效"))
+		quoteSessionJob(bot, user, chatID, quote.Files, quote.Multi)
+		return
+	}
+
+	bot.Send(tgbotapi.NewEditMessageText(chatID, quote.MessageID, fmt.Sprintf("✅ 已确认报价：%.2f 积分", q.Charge())))
+	enqueueSessionJob(bot, user, chatID, quote.Files, quote.Multi, q)
+}
+
+func cancelQuote(bot *tgbotapi.BotAPI, user *User, chatID int64) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		return
+	}
+	processData := data.(map[string]interface{})
+	if quote, ok := processData["quote"].(*pendingQuote); ok {
+		delete(processData, "quote")
+		bot.Send(tgbotapi.NewEditMessageText(chatID, quote.MessageID, "🚫 已取消，未扣除积分。可重新发送文件"))
+	}
+}
+
+/******************* 管理员计费命令 *******************/
+// /pricing | /pricing set base|file|pair|mb|rate|free <值>
+func handlePricingCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		rules := pricingRules()
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(`💰 计费规则
+基础价格 (base)：%.2f
+第一个之外每个文件 (file)：%.2f
+每个代码对 (pair)：%.2f
+每 MB (mb)：%.2f
+封顶倍数 (rate)：%.2f（0 为不封顶）
+每日免费次数 (free)：%d
+使用共享预设时基础价格为预设价格`,
+			rules.Base, rules.PerFile, rules.PerPair, rules.PerMB, rules.MaxRate, rules.FreeDaily)))
+		return
+	}
+
+	if len(args) != 3 || args[0] != "set" {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 用法：/pricing set base|file|pair|mb|rate|free <值>"))
+		return
+	}
+	value, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || value < 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 无效的数值"))
+		return
+	}
+
+	pricingMu.Lock()
+	rules := &pricing.Rules
+	switch args[1] {
+	case "base":
+		rules.Base = value
+	case "file":
+		rules.PerFile = value
+	case "pair":
+		rules.PerPair = value
+	case "mb":
+		rules.PerMB = value
+	case "rate":
+		rules.MaxRate = value
+	case "free":
+		rules.FreeDaily = int(value)
+	default:
+		pricingMu.Unlock()
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 未知的计费项: "+args[1]))
+		return
+	}
+	pricingMu.Unlock()
+	savePricing()
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ 已将 %s 设置为 %s", args[1], args[2])))
+}
+
+/******************* 加载/保存 计费规则 *******************/
+func loadPricing() {
+	file, err := ioutil.ReadFile(pricingFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取计费规则文件失败: %v", err)
+		}
+		return
+	}
+	pricingMu.Lock()
+	defer pricingMu.Unlock()
+	if err := json.Unmarshal(file, &pricing); err != nil {
+		log.Printf("解析计费规则文件失败: %v", err)
+	}
+	if pricing.FreeUsed == nil {
+		pricing.FreeUsed = make(map[string]map[int64]int)
+	}
+}
+
+func savePricing() {
+	pricingMu.Lock()
+	data, err := json.MarshalIndent(pricing, "", "  ")
+	pricingMu.Unlock()
+	if err != nil {
+		log.Printf("序列化计费规则失败: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(pricingFile, data, 0644); err != nil {
+		log.Printf("保存计费规则失败: %v", err)
+	}
+}
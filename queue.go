@@ -0,0 +1,605 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 任务状态
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+)
+
+var (
+	queueFile         = "queue.json"
+	maxRunningJobs    = 2               // 同时运行的美化任务数
+	jobStatusInterval = 2 * time.Second // 进度消息的最短更新间隔
+)
+
+// 用户取消任务时处理流程返回的错误
+var errJobCanceled = errors.New("任务已取消")
+
+// 任务要处理的文件：记录 Telegram 文件 ID，重启后可重新下载；Path 非空且存在时直接使用本地副本
+type JobFile struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`
+	Path         string `json:"path,omitempty"`
+}
+
+// 队列中的美化任务，开始时从会话中复制代码对和选项，之后与会话无关
+type BeautifyJob struct {
+	ID        string          `json:"id"`
+	UserID    int64           `json:"user_id"`
+	ChatID    int64           `json:"chat_id"`
+	Files     []JobFile       `json:"files"`
+	Multi     bool            `json:"multi"` // 多文件任务，结果合并为一个压缩包
+	Codes     []CodePair      `json:"codes"`
+	Options   BeautifyOptions `json:"options"`
+	Cost      float64         `json:"cost"`              // 确认的报价，使用免费次数时为 0
+	Library   string          `json:"library,omitempty"` // 使用的共享预设，任务成功后计入使用次数
+	MessageID int             `json:"message_id"`        // 原地更新的状态消息
+	State     string          `json:"state"`
+	Delivered bool            `json:"delivered,omitempty"` // 结果已发送，即将结算
+	CreatedAt time.Time       `json:"created_at"`
+
+	control *JobControl
+	charged float64    // 结算时实际扣除的积分
+	output  *JobOutput // 已发送的结果文件
+}
+
+// 任务记录中显示的文件名
+func (job *BeautifyJob) displayName() string {
+	if len(job.Files) == 0 {
+		return ""
+	}
+	if job.Multi {
+		return fmt.Sprintf("%s 等%d个文件", filepath.Base(job.Files[0].Name), len(job.Files))
+	}
+	return job.Files[0].Name
+}
+
+// 运行中任务的取消信号和进度
+type JobControl struct {
+	canceled   chan struct{}
+	cancelOnce sync.Once
+	patched    int32
+	onProgress func()
+}
+
+func newJobControl() *JobControl {
+	return &JobControl{canceled: make(chan struct{})}
+}
+
+func (c *JobControl) Cancel() {
+	c.cancelOnce.Do(func() { close(c.canceled) })
+}
+
+// 未关联任务（如预览）时 c 为 nil，始终返回 false
+func (c *JobControl) Canceled() bool {
+	if c == nil {
+		return false
+	}
+	select {
+	case <-c.canceled:
+		return true
+	default:
+		return false
+	}
+}
+
+// 记录一个文件处理完成
+func (c *JobControl) filePatched() {
+	if c == nil {
+		return
+	}
+	atomic.AddInt32(&c.patched, 1)
+	if c.onProgress != nil {
+		c.onProgress()
+	}
+}
+
+func (c *JobControl) Patched() int {
+	return int(atomic.LoadInt32(&c.patched))
+}
+
+var (
+	jobQueue     = make([]*BeautifyJob, 0) // 排队和运行中的任务，按加入顺序排列
+	jobQueueMu   sync.Mutex
+	jobQueueCond = sync.NewCond(&jobQueueMu)
+)
+
+/******************* 加入队列 *******************/
+// 从会话中复制代码对和选项，按确认的报价生成任务并结束会话
+func enqueueSessionJob(bot *tgbotapi.BotAPI, user *User, chatID int64, files []JobFile, multi bool, quote *PriceQuote) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 处理会话已过期"))
+		return
+	}
+
+	processData := data.(map[string]interface{})
+	codes, ok := processData["codes"].([]CodePair)
+	if !ok || len(codes) == 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 未找到有效的代码对，请先发送代码对"))
+		return
+	}
+
+	opts := *sessionOptions(processData)
+	opts.Preview = false
+	job := &BeautifyJob{
+		ID:        fmt.Sprintf("%d-%d", user.ID, time.Now().UnixNano()),
+		UserID:    user.ID,
+		ChatID:    chatID,
+		Files:     files,
+		Multi:     multi,
+		Codes:     append([]CodePair(nil), codes...),
+		Options:   opts,
+		Cost:      quote.Charge(),
+		Library:   sessionLibraryPreset(processData),
+		State:     JobQueued,
+		CreatedAt: time.Now(),
+		control:   newJobControl(),
+	}
+
+	// 按报价冻结积分，积分不足时保留会话
+	if err := reservePoints(user, job.ID, job.Cost, quote.Free); err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ "+err.Error()))
+		return
+	}
+
+	// 文件交给任务处理，会话到此结束
+	for _, f := range files {
+		if cached, ok := processData["preview_file"].(*PreviewFile); ok && cached.Path == f.Path {
+			delete(processData, "preview_file")
+		}
+	}
+	clearPreviewFile(processData)
+	clearPendingFiles(processData)
+	processingUsers.Delete(user.ID)
+
+	msg := tgbotapi.NewMessage(chatID, job.statusText("🕒 已加入队列"))
+	msg.ReplyMarkup = jobCancelButton(job.ID)
+	if sent, err := bot.Send(msg); err == nil {
+		job.MessageID = sent.MessageID
+	}
+
+	jobQueueMu.Lock()
+	jobQueue = append(jobQueue, job)
+	saveJobQueueLocked()
+	jobQueueCond.Signal()
+	jobQueueMu.Unlock()
+	refreshQueuePositions(bot)
+}
+
+// 单个文件的任务，已预览过的同一文件直接使用本地副本
+func documentJobFile(userID int64, document *tgbotapi.Document) JobFile {
+	file := JobFile{
+		FileID:       document.FileID,
+		FileUniqueID: document.FileUniqueID,
+		Name:         document.FileName,
+		Size:         int64(document.FileSize),
+	}
+	if cached := sessionPreviewFile(userID); cached != nil && cached.FileUniqueID == document.FileUniqueID {
+		file.Path = cached.Path
+	}
+	return file
+}
+
+/******************* 状态消息 *******************/
+func (job *BeautifyJob) title() string {
+	if job.Multi {
+		return fmt.Sprintf("📦 多文件任务（%d 个文件）", len(job.Files))
+	}
+	return "📄 " + job.Files[0].Name
+}
+
+func (job *BeautifyJob) statusText(status string) string {
+	return job.title() + "\n" + status
+}
+
+func jobCancelButton(id string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("❌ 取消任务", "job_cancel:"+id),
+		),
+	)
+}
+
+// 原地更新状态消息，运行中的任务保留取消按钮
+func (job *BeautifyJob) setStatus(bot *tgbotapi.BotAPI, status string) {
+	if job.MessageID == 0 {
+		return
+	}
+	text := job.statusText(status)
+	if job.State == JobQueued || job.State == JobRunning {
+		bot.Send(tgbotapi.NewEditMessageTextAndMarkup(job.ChatID, job.MessageID, text, jobCancelButton(job.ID)))
+	} else {
+		bot.Send(tgbotapi.NewEditMessageText(job.ChatID, job.MessageID, text))
+	}
+}
+
+// 限制更新频率的进度更新
+func (job *BeautifyJob) throttledStatus(bot *tgbotapi.BotAPI) func(string) {
+	var mu sync.Mutex
+	last := time.Time{}
+	return func(status string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(last) < jobStatusInterval {
+			return
+		}
+		last = time.Now()
+		job.setStatus(bot, status)
+	}
+}
+
+// 更新所有排队任务的排队位置
+func refreshQueuePositions(bot *tgbotapi.BotAPI) {
+	jobQueueMu.Lock()
+	waiting := make([]*BeautifyJob, 0)
+	for _, job := range jobQueue {
+		if job.State == JobQueued {
+			waiting = append(waiting, job)
+		}
+	}
+	running := len(jobQueue) - len(waiting)
+	jobQueueMu.Unlock()
+
+	for i, job := range waiting {
+		if i == 0 && running < maxRunningJobs {
+			continue // 即将开始运行
+		}
+		job.setStatus(bot, fmt.Sprintf("🕒 排队中，前面还有 %d 个任务", running+i))
+	}
+}
+
+/******************* 取消任务 *******************/
+func cancelJob(bot *tgbotapi.BotAPI, user *User, chatID int64, id string) {
+	jobQueueMu.Lock()
+	var job *BeautifyJob
+	removed := false
+	for i, j := range jobQueue {
+		if j.ID != id || j.UserID != user.ID {
+			continue
+		}
+		job = j
+		if j.State == JobQueued {
+			// 尚未开始的任务直接移出队列
+			j.State = JobCanceled
+			jobQueue = append(jobQueue[:i], jobQueue[i+1:]...)
+			saveJobQueueLocked()
+			removed = true
+		}
+		break
+	}
+	jobQueueMu.Unlock()
+
+	if job == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 任务不存在或已结束"))
+		return
+	}
+	if removed {
+		releaseCharge(job.ID)
+		recordJob(job, job.displayName(), 0, nil, errJobCanceled)
+		job.setStatus(bot, "🚫 任务已取消，未扣除积分")
+		removeJobFiles(job)
+		refreshQueuePositions(bot)
+		return
+	}
+	job.control.Cancel()
+	job.setStatus(bot, "🚫 正在取消...")
+}
+
+/******************* 任务调度 *******************/
+// 启动固定数量的工作协程，全局同时运行的任务数不超过 maxRunningJobs
+func startJobWorkers(bot *tgbotapi.BotAPI) {
+	for i := 0; i < maxRunningJobs; i++ {
+		go jobWorker(bot)
+	}
+	refreshQueuePositions(bot)
+}
+
+func jobWorker(bot *tgbotapi.BotAPI) {
+	for {
+		jobQueueMu.Lock()
+		job := nextQueuedJob()
+		for job == nil {
+			jobQueueCond.Wait()
+			job = nextQueuedJob()
+		}
+		job.State = JobRunning
+		saveJobQueueLocked()
+		jobQueueMu.Unlock()
+		refreshQueuePositions(bot)
+
+		err := runJob(bot, job)
+		// 结果发送成功时已结算，其余情况解冻积分；下载失败、异常和取消同样记录结果
+		releaseCharge(job.ID)
+		recordJob(job, job.displayName(), job.charged, job.output, err)
+
+		jobQueueMu.Lock()
+		switch {
+		case errors.Is(err, errJobCanceled):
+			job.State = JobCanceled
+		case err != nil:
+			job.State = JobFailed
+		default:
+			job.State = JobDone
+		}
+		for i, j := range jobQueue {
+			if j == job {
+				jobQueue = append(jobQueue[:i], jobQueue[i+1:]...)
+				break
+			}
+		}
+		saveJobQueueLocked()
+		jobQueueMu.Unlock()
+
+		switch job.State {
+		case JobCanceled:
+			job.setStatus(bot, "🚫 任务已取消，未扣除积分")
+		case JobFailed:
+			job.setStatus(bot, "❌ 任务失败："+err.Error())
+		default:
+			job.setStatus(bot, "✅ 任务完成")
+			if job.Library != "" {
+				recordLibraryUse(job.Library)
+			}
+		}
+		refreshQueuePositions(bot)
+	}
+}
+
+func nextQueuedJob() *BeautifyJob {
+	for _, job := range jobQueue {
+		if job.State == JobQueued {
+			return job
+		}
+	}
+	return nil
+}
+
+// 下载文件并按任务类型处理，返回任务的最终错误
+func runJob(bot *tgbotapi.BotAPI, job *BeautifyJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("美化任务异常: %v", r)
+			err = fmt.Errorf("内部错误: %v", r)
+		}
+	}()
+	defer removeJobFiles(job)
+
+	mu.Lock()
+	user, exists := users[job.UserID]
+	mu.Unlock()
+	if !exists {
+		return errors.New("用户不存在")
+	}
+
+	workDir, err := ioutil.TempDir("", "job_*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	update := job.throttledStatus(bot)
+	job.control.onProgress = func() {
+		update(fmt.Sprintf("⚙️ 正在处理：已处理 %d 个文件", job.control.Patched()))
+	}
+
+	paths, err := downloadJobFiles(bot, job, workDir, update)
+	if err != nil {
+		if !errors.Is(err, errJobCanceled) {
+			bot.Send(tgbotapi.NewMessage(job.ChatID, "❌ 文件下载失败"))
+		}
+		return err
+	}
+
+	job.setStatus(bot, "⚙️ 正在处理...")
+	if job.Multi {
+		return processMultiFile(bot, job, user, workDir, paths)
+	}
+	name := job.Files[0].Name
+	if archiveFormat(name) != "" {
+		return processArchiveFile(bot, job, user, paths[0], name)
+	}
+	return processSingleFile(bot, job, user, paths[0], name)
+}
+
+// 任务选项，附带取消信号和进度
+func (job *BeautifyJob) options() *BeautifyOptions {
+	opts := job.Options
+	opts.Control = job.control
+	return &opts
+}
+
+/******************* 下载任务文件 *******************/
+// 下载到 dir 中，多文件任务按文件名保存，返回各文件的本地路径
+func downloadJobFiles(bot *tgbotapi.BotAPI, job *BeautifyJob, dir string, update func(string)) ([]string, error) {
+	total := int64(0)
+	for _, f := range job.Files {
+		total += f.Size
+	}
+
+	done := int64(0)
+	paths := make([]string, 0, len(job.Files))
+	names := make([]string, 0, len(job.Files))
+	for i, f := range job.Files {
+		dest := filepath.Join(dir, fmt.Sprintf("input_%d", i))
+		if job.Multi {
+			name := jobFileName(f.Name, i, names)
+			names = append(names, name)
+			dest = filepath.Join(dir, name)
+		}
+
+		if f.Path != "" {
+			if err := copyFile(f.Path, dest); err == nil {
+				done += f.Size
+				paths = append(paths, dest)
+				continue
+			}
+		}
+		if f.FileID == "" {
+			return nil, fmt.Errorf("%s 的本地副本已失效", f.Name)
+		}
+
+		progress := func(n int64) {
+			if total > 0 {
+				update(fmt.Sprintf("⬇️ 正在下载：%d%%", (done+n)*100/total))
+			}
+		}
+		if err := downloadTelegramFile(bot, f.FileID, dest, job.control, progress); err != nil {
+			return nil, err
+		}
+		done += f.Size
+		paths = append(paths, dest)
+	}
+	return paths, nil
+}
+
+// 多文件任务保存到任务目录中的文件名：只取最后一段，不安全或为空时按序号命名，同名文件加上序号
+func jobFileName(name string, i int, used []string) string {
+	base := path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if clean, err := sanitizeEntryPath(base); err != nil || clean == "." {
+		base = fmt.Sprintf("file_%d", i+1)
+	}
+	return uniqueFileName(used, base)
+}
+
+func downloadTelegramFile(bot *tgbotapi.BotAPI, fileID, dest string, control *JobControl, progress func(int64)) error {
+	fileURL, err := bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Get(fileURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("下载失败: %s", resp.Status)
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, &progressReader{r: resp.Body, control: control, progress: progress})
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// 统计已读取的字节数，任务被取消时中止读取
+type progressReader struct {
+	r        io.Reader
+	n        int64
+	control  *JobControl
+	progress func(int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	if p.control.Canceled() {
+		return 0, errJobCanceled
+	}
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	p.progress(p.n)
+	return n, err
+}
+
+// 删除任务持有的本地副本（如预览时下载的文件）
+func removeJobFiles(job *BeautifyJob) {
+	for _, f := range job.Files {
+		if f.Path != "" {
+			os.Remove(f.Path)
+		}
+	}
+}
+
+/******************* 队列持久化 *******************/
+func saveJobQueueLocked() {
+	data, err := json.MarshalIndent(jobQueue, "", "  ")
+	if err != nil {
+		log.Printf("序列化任务队列失败: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(queueFile, data, 0644); err != nil {
+		log.Printf("保存任务队列失败: %v", err)
+	}
+}
+
+func saveJobQueue() {
+	jobQueueMu.Lock()
+	defer jobQueueMu.Unlock()
+	saveJobQueueLocked()
+}
+
+// 结算前写入队列文件，重启时已交付的任务不再运行
+func markJobDelivered(job *BeautifyJob) {
+	jobQueueMu.Lock()
+	defer jobQueueMu.Unlock()
+	job.Delivered = true
+	saveJobQueueLocked()
+}
+
+// 启动时恢复队列，重启前运行中的任务重新排队
+func loadJobQueue() {
+	data, err := ioutil.ReadFile(queueFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("读取任务队列失败: %v", err)
+		}
+		return
+	}
+
+	jobQueueMu.Lock()
+	defer jobQueueMu.Unlock()
+	if err := json.Unmarshal(data, &jobQueue); err != nil {
+		log.Printf("解析任务队列失败: %v", err)
+		jobQueue = make([]*BeautifyJob, 0)
+		return
+	}
+	// 已交付的任务不再运行，冻结记录由 releaseOrphanReservations 解冻
+	pending := jobQueue[:0]
+	for _, job := range jobQueue {
+		if job.Delivered {
+			log.Printf("任务 %s 重启前已交付，不再运行", job.ID)
+			removeJobFiles(job)
+			continue
+		}
+		job.State = JobQueued
+		job.control = newJobControl()
+		pending = append(pending, job)
+	}
+	if len(pending) < len(jobQueue) {
+		jobQueue = pending
+		saveJobQueueLocked()
+	}
+	if len(jobQueue) > 0 {
+		log.Printf("已恢复 %d 个排队中的任务", len(jobQueue))
+	}
+}
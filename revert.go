@@ -0,0 +1,723 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const patchManifestVersion = 1
+
+// 单处字节修改
+type PatchEntry struct {
+	Offset   int    `json:"offset"`
+	Original string `json:"original"` // 修改前的字节（十六进制）
+	New      string `json:"new"`      // 修改后的字节（十六进制）
+}
+
+// 单个文件的修改记录
+type PatchFile struct {
+	Path         string       `json:"path"` // 单文件为文件名，压缩包为包内路径
+	InputSHA256  string       `json:"input_sha256"`
+	OutputSHA256 string       `json:"output_sha256"`
+	Patches      []PatchEntry `json:"patches"`
+	Entries      []PatchFile  `json:"entries,omitempty"` // 嵌套压缩包内被修改的文件
+}
+
+// 补丁清单：随美化结果一同发送，用于还原原始文件
+type PatchManifest struct {
+	Version      int         `json:"version"`
+	InputName    string      `json:"input_name"`
+	InputSHA256  string      `json:"input_sha256"`
+	OutputName   string      `json:"output_name"`
+	OutputSHA256 string      `json:"output_sha256"`
+	CreatedAt    time.Time   `json:"created_at"`
+	Files        []PatchFile `json:"files"`
+	Archive      []ArchiveOp `json:"archive,omitempty"` // 由美化后的压缩包逐字节重建原始压缩包
+}
+
+// 重建原始压缩包的一步：Data 不为空时写入原始字节，否则从美化后的压缩包复制 Offset 起的 Length 字节
+type ArchiveOp struct {
+	Offset int64  `json:"offset,omitempty"`
+	Length int64  `json:"length,omitempty"`
+	Data   []byte `json:"data,omitempty"` // 原始字节（base64）
+}
+
+var revertCacheDir = filepath.Join(os.TempDir(), "tgbot_revert")
+
+/******************* 生成补丁 *******************/
+// 比较修改前后的内容，连续变化的字节合并为一条记录
+func diffFile(path string, original, modified []byte) PatchFile {
+	patch := PatchFile{
+		Path:         filepath.ToSlash(path),
+		InputSHA256:  sha256Hex(original),
+		OutputSHA256: sha256Hex(modified),
+		Patches:      make([]PatchEntry, 0),
+	}
+
+	for i := 0; i < len(original) && i < len(modified); {
+		if original[i] == modified[i] {
+			i++
+			continue
+		}
+		j := i
+		for j < len(original) && j < len(modified) && original[j] != modified[j] {
+			j++
+		}
+		patch.Patches = append(patch.Patches, PatchEntry{
+			Offset:   i,
+			Original: strings.ToUpper(hex.EncodeToString(original[i:j])),
+			New:      strings.ToUpper(hex.EncodeToString(modified[i:j])),
+		})
+		i = j
+	}
+	return patch
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 分块比较磁盘上修改前后的两个文件，结果与 diffFile 一致
+func diffFiles(path, originalPath, modifiedPath string) (PatchFile, error) {
+	patch := PatchFile{Path: filepath.ToSlash(path), Patches: make([]PatchEntry, 0)}
+
+	a, err := os.Open(originalPath)
+	if err != nil {
+		return patch, err
+	}
+	defer a.Close()
+	b, err := os.Open(modifiedPath)
+	if err != nil {
+		return patch, err
+	}
+	defer b.Close()
+
+	hashA, hashB := sha256.New(), sha256.New()
+	bufA, bufB := make([]byte, streamChunkSize), make([]byte, streamChunkSize)
+	var runA, runB []byte
+	runStart := -1
+	flush := func() {
+		if runStart < 0 {
+			return
+		}
+		patch.Patches = append(patch.Patches, PatchEntry{
+			Offset:   runStart,
+			Original: strings.ToUpper(hex.EncodeToString(runA)),
+			New:      strings.ToUpper(hex.EncodeToString(runB)),
+		})
+		runA, runB, runStart = runA[:0], runB[:0], -1
+	}
+
+	for offset := 0; ; {
+		na, errA := io.ReadFull(a, bufA)
+		nb, errB := io.ReadFull(b, bufB)
+		for _, e := range []error{errA, errB} {
+			if e != nil && e != io.EOF && e != io.ErrUnexpectedEOF {
+				return patch, e
+			}
+		}
+		hashA.Write(bufA[:na])
+		hashB.Write(bufB[:nb])
+
+		n := na
+		if nb < n {
+			n = nb
+		}
+		for i := 0; i < n; i++ {
+			if bufA[i] == bufB[i] {
+				flush()
+				continue
+			}
+			if runStart < 0 {
+				runStart = offset + i
+			}
+			runA = append(runA, bufA[i])
+			runB = append(runB, bufB[i])
+		}
+		offset += n
+		if errA != nil || errB != nil {
+			break
+		}
+	}
+	flush()
+
+	patch.InputSHA256 = hex.EncodeToString(hashA.Sum(nil))
+	patch.OutputSHA256 = hex.EncodeToString(hashB.Sum(nil))
+	return patch, nil
+}
+
+/******************* 发送补丁清单 *******************/
+func sendPatchManifest(bot *tgbotapi.BotAPI, chatID int64, manifest *PatchManifest) {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return
+	}
+
+	name := strings.TrimSuffix(manifest.OutputName, filepath.Ext(manifest.OutputName)) + ".patch.json"
+	msg := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: name, Bytes: data})
+	msg.Caption = "🧾 补丁清单：如需还原原始文件，请发送 /revert 并上传修改后的文件和本清单"
+	bot.Send(msg)
+}
+
+/******************* 还原流程 *******************/
+func handleRevertCommand(bot *tgbotapi.BotAPI, user *User, chatID int64) {
+	if data, ok := processingUsers.Load(user.ID); ok {
+		processData := data.(map[string]interface{})
+		clearPreviewFile(processData)
+		clearRevertFile(processData)
+	}
+
+	processingUsers.Store(user.ID, map[string]interface{}{
+		"step":          "waiting_revert",
+		"last_activity": time.Now(),
+		"chat_id":       chatID,
+		"bot":           bot,
+	})
+	bot.Send(tgbotapi.NewMessage(chatID, "♻️ 请依次发送美化后的文件（.dat 或压缩包）和对应的补丁清单（.patch.json），顺序不限。还原免费。"))
+}
+
+func isRevertSession(userID int64) bool {
+	data, ok := processingUsers.Load(userID)
+	if !ok {
+		return false
+	}
+	step, _ := data.(map[string]interface{})["step"].(string)
+	return step == "waiting_revert"
+}
+
+// 删除还原会话中缓存的文件
+func clearRevertFile(processData map[string]interface{}) {
+	if path, ok := processData["revert_file"].(string); ok {
+		os.Remove(path)
+		delete(processData, "revert_file")
+	}
+}
+
+// 接收还原所需的文件，两个文件都到齐后执行还原
+func handleRevertFile(bot *tgbotapi.BotAPI, user *User, chatID int64, filePath string, fileName string) {
+	data, ok := processingUsers.Load(user.ID)
+	if !ok {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 还原会话已过期，请重新发送 /revert"))
+		return
+	}
+	processData := data.(map[string]interface{})
+	processData["last_activity"] = time.Now()
+
+	switch {
+	case strings.HasSuffix(fileName, ".json"):
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ 读取文件失败"))
+			return
+		}
+		var manifest PatchManifest
+		if err := json.Unmarshal(content, &manifest); err != nil || manifest.Version == 0 || manifest.OutputSHA256 == "" {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ 无效的补丁清单"))
+			return
+		}
+		processData["revert_manifest"] = &manifest
+		bot.Send(tgbotapi.NewMessage(chatID, "✅ 已收到补丁清单"))
+
+	default:
+		// 其余文件均视为美化后的文件，由补丁清单校验是否匹配
+		if err := os.MkdirAll(revertCacheDir, 0755); err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ 缓存文件失败"))
+			return
+		}
+		cachePath := filepath.Join(revertCacheDir, fmt.Sprintf("%d%s", user.ID, filepath.Ext(fileName)))
+		clearRevertFile(processData)
+		if err := copyFile(filePath, cachePath); err != nil {
+			bot.Send(tgbotapi.NewMessage(chatID, "❌ 缓存文件失败"))
+			return
+		}
+		processData["revert_file"] = cachePath
+		processData["revert_file_name"] = fileName
+		bot.Send(tgbotapi.NewMessage(chatID, "✅ 已收到修改后的文件"))
+	}
+	processingUsers.Store(user.ID, processData)
+
+	manifest, hasManifest := processData["revert_manifest"].(*PatchManifest)
+	modifiedPath, hasFile := processData["revert_file"].(string)
+	if !hasManifest || !hasFile {
+		return
+	}
+
+	defer func() {
+		clearRevertFile(processData)
+		processingUsers.Delete(user.ID)
+	}()
+
+	// 还原结果写入临时文件并流式上传，不整体读入内存
+	out, err := ioutil.TempFile("", "revert_output_*")
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 创建临时文件失败"))
+		return
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	if err := revertFile(modifiedPath, out.Name(), manifest); err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 还原失败: "+err.Error()))
+		return
+	}
+
+	restored, err := os.Open(out.Name())
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ 读取还原结果失败"))
+		return
+	}
+	defer restored.Close()
+	msg := tgbotapi.NewDocument(chatID, tgbotapi.FileReader{Name: manifest.InputName, Reader: restored})
+	msg.Caption = "✅ 已还原为原始文件"
+	bot.Send(msg)
+}
+
+/******************* 执行还原 *******************/
+// 将还原出的原始文件写入 dest
+func revertFile(modifiedPath, dest string, manifest *PatchManifest) error {
+	outputHash, err := sha256File(modifiedPath)
+	if err != nil {
+		return err
+	}
+	if outputHash != manifest.OutputSHA256 {
+		return errors.New("文件与补丁清单不匹配（SHA-256 校验失败）")
+	}
+
+	format := archiveFormat(manifest.OutputName)
+	switch {
+	case len(manifest.Archive) > 0:
+		// 重新压缩过的条目无法由包内文件逐字节还原，直接按字节补丁重建原始压缩包
+		if err := applyArchivePatch(modifiedPath, dest, manifest.Archive); err != nil {
+			return err
+		}
+	case format != "":
+		// 没有字节补丁的旧清单按包内文件还原后重新打包
+		if err := copyFile(modifiedPath, dest); err != nil {
+			return err
+		}
+		if err := revertArchiveFile(dest, format, manifest.InputName, manifest.Files, newExtractBudget()); err != nil {
+			return err
+		}
+	default:
+		if len(manifest.Files) != 1 {
+			return errors.New("补丁清单格式错误")
+		}
+		if err := copyFile(modifiedPath, dest); err != nil {
+			return err
+		}
+		if err := applyReversePatch(dest, manifest.Files[0]); err != nil {
+			return err
+		}
+	}
+
+	inputHash, err := sha256File(dest)
+	if err != nil {
+		return err
+	}
+	if inputHash != manifest.InputSHA256 {
+		if format != "" && len(manifest.Archive) == 0 {
+			// 包内文件均已逐个校验，但重新压缩的条目与原包字节不同时仍拒绝发送
+			return errors.New("包内文件已还原，但重新打包后的压缩包与原始文件不一致（SHA-256 校验失败），无法逐字节还原")
+		}
+		return errors.New("还原后的文件与原始文件不一致（SHA-256 校验失败）")
+	}
+	return nil
+}
+
+// 按清单在 path 上原地将文件还原为修改前的字节，只读写补丁记录涉及的区域
+func applyReversePatch(path string, patch PatchFile) error {
+	hash, err := sha256File(path)
+	if err != nil {
+		return err
+	}
+	if hash != patch.OutputSHA256 {
+		return fmt.Errorf("%s 与补丁清单不匹配", patch.Path)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	for i := len(patch.Patches) - 1; i >= 0; i-- {
+		entry := patch.Patches[i]
+		original, err1 := hex.DecodeString(entry.Original)
+		modified, err2 := hex.DecodeString(entry.New)
+		if err1 != nil || err2 != nil || len(original) != len(modified) || entry.Offset < 0 || int64(entry.Offset+len(modified)) > info.Size() {
+			return fmt.Errorf("%s 的补丁记录无效", patch.Path)
+		}
+		current := make([]byte, len(modified))
+		if _, err := f.ReadAt(current, int64(entry.Offset)); err != nil {
+			return err
+		}
+		if !bytes.Equal(current, modified) {
+			return fmt.Errorf("%s 偏移 0x%X 处的内容与补丁清单不符", patch.Path, entry.Offset)
+		}
+		if _, err := f.WriteAt(original, int64(entry.Offset)); err != nil {
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if hash, err = sha256File(path); err != nil {
+		return err
+	}
+	if hash != patch.InputSHA256 {
+		return fmt.Errorf("%s 还原后校验失败", patch.Path)
+	}
+	return nil
+}
+
+// 解压后按补丁还原包内文件，嵌套压缩包递归还原，再按原结构重建并替换 path
+func revertArchiveFile(path, format, name string, files []PatchFile, budget *extractBudget) error {
+	workDir, err := ioutil.TempDir("", "revert_archive_*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	if err := extractArchive(format, path, name, workDir, budget); err != nil {
+		return err
+	}
+
+	for _, patch := range files {
+		target, err := safeJoin(workDir, patch.Path)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(target); err != nil {
+			return fmt.Errorf("压缩包中缺少补丁清单记录的文件 %s", patch.Path)
+		}
+
+		if len(patch.Entries) > 0 {
+			innerFormat := archiveFormat(patch.Path)
+			hash, err := sha256File(target)
+			if err != nil {
+				return err
+			}
+			if innerFormat == "" || hash != patch.OutputSHA256 {
+				return fmt.Errorf("%s 与补丁清单不匹配", patch.Path)
+			}
+			if err := revertArchiveFile(target, innerFormat, filepath.Base(target), patch.Entries, budget); err != nil {
+				return fmt.Errorf("%s: %w", patch.Path, err)
+			}
+			continue
+		}
+
+		if err := applyReversePatch(target, patch); err != nil {
+			return err
+		}
+	}
+
+	return replaceArchive(format, path, name, workDir, changedPaths(files))
+}
+
+/******************* 压缩包字节补丁 *******************/
+// 重新压缩的条目（以及 tar.gz、gz 的整个数据流）与原始字节不同，只凭包内文件无法逐字节还原，
+// 因此另外记录由美化后的压缩包重建原始压缩包所需的字节补丁：与美化后内容相同的区域只记录偏移，其余保存原始字节
+
+const (
+	archivePatchMinBlock  = 1024     // 匹配块的最小长度
+	archivePatchMaxBlocks = 1 << 16  // 索引的块数上限，文件较大时相应增大块长度
+	archivePatchMaxData   = 20 << 20 // 补丁中原始字节的上限，超出时不附带字节补丁
+	archivePatchMaxSlots  = 8        // 同一个弱哈希最多记录的块数
+)
+
+// 比较原始压缩包与美化后的压缩包，出错或补丁过大时记录日志并返回 nil
+func archivePatchFor(originalPath, modifiedPath string) []ArchiveOp {
+	ops, err := diffArchive(originalPath, modifiedPath)
+	if err != nil {
+		log.Printf("生成压缩包字节补丁失败: %v", err)
+		return nil
+	}
+	return ops
+}
+
+// 类似 rsync：按块索引美化后的文件，在原始文件上滚动查找相同的块，找到后尽量向后延伸
+func diffArchive(originalPath, modifiedPath string) ([]ArchiveOp, error) {
+	orig, err := os.Open(originalPath)
+	if err != nil {
+		return nil, err
+	}
+	defer orig.Close()
+	mod, err := os.Open(modifiedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer mod.Close()
+
+	origInfo, err := orig.Stat()
+	if err != nil {
+		return nil, err
+	}
+	modInfo, err := mod.Stat()
+	if err != nil {
+		return nil, err
+	}
+	origSize, modSize := origInfo.Size(), modInfo.Size()
+
+	block := int64(archivePatchMinBlock)
+	if n := modSize / archivePatchMaxBlocks; n > block {
+		block = n
+	}
+	index, err := indexBlocks(mod, modSize, block)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]ArchiveOp, 0)
+	var dataSize int64
+	literal := int64(0) // 尚未输出的原始字节的起点
+	flush := func(end int64) error {
+		if end <= literal {
+			return nil
+		}
+		if dataSize += end - literal; dataSize > archivePatchMaxData {
+			return fmt.Errorf("补丁超过 %d 字节", archivePatchMaxData)
+		}
+		data := make([]byte, end-literal)
+		if _, err := orig.ReadAt(data, literal); err != nil {
+			return err
+		}
+		ops = append(ops, ArchiveOp{Data: data})
+		return nil
+	}
+
+	window := &fileWindow{f: orig, size: origSize}
+	candidate := make([]byte, block)
+	pos := int64(0)
+	var hash rollingHash
+	if pos+block <= origSize {
+		data, err := window.at(pos, block)
+		if err != nil {
+			return nil, err
+		}
+		hash.reset(data)
+	}
+	for pos+block <= origSize {
+		matched := int64(-1)
+		for _, offset := range index[hash.sum()] {
+			data, err := window.at(pos, block)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := mod.ReadAt(candidate, offset); err != nil {
+				return nil, err
+			}
+			if bytes.Equal(data, candidate) {
+				matched = offset
+				break
+			}
+		}
+
+		if matched < 0 {
+			if pos+block == origSize {
+				break
+			}
+			data, err := window.at(pos, block+1)
+			if err != nil {
+				return nil, err
+			}
+			hash.roll(data[0], data[block])
+			pos++
+			continue
+		}
+
+		length, err := matchLength(window, mod, pos, matched, origSize, modSize)
+		if err != nil {
+			return nil, err
+		}
+		if err := flush(pos); err != nil {
+			return nil, err
+		}
+		if last := len(ops) - 1; last >= 0 && ops[last].Data == nil && ops[last].Offset+ops[last].Length == matched {
+			ops[last].Length += length
+		} else {
+			ops = append(ops, ArchiveOp{Offset: matched, Length: length})
+		}
+		pos += length
+		literal = pos
+		if pos+block <= origSize {
+			data, err := window.at(pos, block)
+			if err != nil {
+				return nil, err
+			}
+			hash.reset(data)
+		}
+	}
+	if err := flush(origSize); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// 按 block 对齐切分文件，记录每个块的弱哈希
+func indexBlocks(f *os.File, size, block int64) (map[uint32][]int64, error) {
+	index := make(map[uint32][]int64)
+	buf := make([]byte, block)
+	var hash rollingHash
+	for offset := int64(0); offset+block <= size; offset += block {
+		if _, err := f.ReadAt(buf, offset); err != nil {
+			return nil, err
+		}
+		hash.reset(buf)
+		if slots := index[hash.sum()]; len(slots) < archivePatchMaxSlots {
+			index[hash.sum()] = append(slots, offset)
+		}
+	}
+	return index, nil
+}
+
+// 从两个文件的给定位置起相同的字节数
+func matchLength(window *fileWindow, mod *os.File, origPos, modPos, origSize, modSize int64) (int64, error) {
+	buf := make([]byte, streamChunkSize)
+	length := int64(0)
+	for origPos+length < origSize && modPos+length < modSize {
+		n := int64(len(buf))
+		if rest := origSize - origPos - length; rest < n {
+			n = rest
+		}
+		if rest := modSize - modPos - length; rest < n {
+			n = rest
+		}
+		data, err := window.at(origPos+length, n)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := mod.ReadAt(buf[:n], modPos+length); err != nil {
+			return 0, err
+		}
+		i := int64(0)
+		for i < n && data[i] == buf[i] {
+			i++
+		}
+		length += i
+		if i < n {
+			break
+		}
+	}
+	return length, nil
+}
+
+// 原始文件的读取窗口，顺序扫描时不必整体读入内存
+type fileWindow struct {
+	f     *os.File
+	size  int64
+	start int64
+	buf   []byte
+}
+
+// 返回 [offset, offset+n) 的内容，不在窗口内时从 offset 起重新读取
+func (w *fileWindow) at(offset, n int64) ([]byte, error) {
+	if offset < w.start || offset+n > w.start+int64(len(w.buf)) {
+		size := int64(streamChunkSize)
+		if n > size {
+			size = n
+		}
+		if rest := w.size - offset; rest < size {
+			size = rest
+		}
+		if cap(w.buf) < int(size) {
+			w.buf = make([]byte, size)
+		}
+		w.buf = w.buf[:size]
+		if _, err := w.f.ReadAt(w.buf, offset); err != nil {
+			return nil, err
+		}
+		w.start = offset
+	}
+	return w.buf[offset-w.start : offset-w.start+n], nil
+}
+
+// rsync 的弱滚动校验和
+type rollingHash struct {
+	a, b uint32
+	n    uint32
+}
+
+func (h *rollingHash) reset(data []byte) {
+	h.a, h.b, h.n = 0, 0, uint32(len(data))
+	for i, c := range data {
+		h.a += uint32(c)
+		h.b += uint32(len(data)-i) * uint32(c)
+	}
+}
+
+// 移出 out 并移入 in
+func (h *rollingHash) roll(out, in byte) {
+	h.a += uint32(in) - uint32(out)
+	h.b += h.a - h.n*uint32(out)
+}
+
+func (h *rollingHash) sum() uint32 {
+	return h.a&0xffff | h.b<<16
+}
+
+// 按字节补丁由美化后的压缩包重建原始压缩包并写入 dest
+func applyArchivePatch(modifiedPath, dest string, ops []ArchiveOp) error {
+	mod, err := os.Open(modifiedPath)
+	if err != nil {
+		return err
+	}
+	defer mod.Close()
+	info, err := mod.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for _, op := range ops {
+		if op.Data != nil {
+			if _, err := out.Write(op.Data); err != nil {
+				return err
+			}
+			continue
+		}
+		if op.Offset < 0 || op.Length <= 0 || op.Offset+op.Length > info.Size() {
+			return errors.New("压缩包字节补丁无效")
+		}
+		if _, err := io.Copy(out, io.NewSectionReader(mod, op.Offset, op.Length)); err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
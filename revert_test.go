@@ -0,0 +1,127 @@
+package main
+
+import (
+	"archive/zip"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeZip(t *testing.T, path string, entries []testEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for _, e := range entries {
+		entry, err := w.CreateHeader(&zip.FileHeader{Name: e.name, Method: zip.Deflate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write(e.body)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 美化压缩包后用补丁清单还原，得到与原始压缩包逐字节相同的文件
+func TestRevertArchiveRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	// 随机内容中不含 0x11，只有 target.dat 会被修改
+	noise := func(n int) []byte {
+		data := make([]byte, n)
+		r.Read(data)
+		for i := range data {
+			data[i] &^= 0x10
+		}
+		return data
+	}
+	target := append(noise(40<<10), 0x11, 0x11, 0x00, 0x22, 0x22)
+	target = append(target, noise(40<<10)...)
+	entries := []testEntry{
+		{name: "a/keep.bin", body: noise(200 << 10)},
+		{name: "a/target.dat", body: target},
+		{name: "b/keep.idx", body: noise(100 << 10)},
+	}
+	codes := []CodePair{{Original: "A", New: "B", HexA: "1111", HexB: "2222", Strategy: MatchStrategy{Mode: MatchAll}}}
+
+	tests := []struct {
+		name   string
+		format string
+		write  func(path string)
+	}{
+		{"test.zip", formatZip, func(path string) { writeZip(t, path, entries) }},
+		{"test.tar.gz", formatTarGz, func(path string) { writeTar(t, path, true, entries) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, tt.name)
+			tt.write(src)
+
+			workDir := filepath.Join(dir, "work")
+			budget := newExtractBudget()
+			if err := extractArchive(tt.format, src, tt.name, workDir, budget); err != nil {
+				t.Fatal(err)
+			}
+			result, err := processDirectory(workDir, budget, codes, defaultBeautifyOptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Files) != 1 {
+				t.Fatalf("修改了 %d 个文件，期望 1 个", len(result.Files))
+			}
+
+			modified := filepath.Join(dir, "modified_"+tt.name)
+			out, err := os.Create(modified)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = repackArchive(tt.format, src, tt.name, workDir, changedPaths(result.Files), out)
+			out.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			inputHash, _ := sha256File(src)
+			outputHash, _ := sha256File(modified)
+			if inputHash == outputHash {
+				t.Fatal("压缩包未被修改")
+			}
+			manifest := &PatchManifest{
+				Version:      patchManifestVersion,
+				InputName:    tt.name,
+				InputSHA256:  inputHash,
+				OutputName:   "modified_" + tt.name,
+				OutputSHA256: outputHash,
+				Files:        result.Files,
+				Archive:      archivePatchFor(src, modified),
+			}
+			if len(manifest.Archive) == 0 {
+				t.Fatal("没有生成压缩包字节补丁")
+			}
+			if tt.format == formatZip {
+				// 未修改的条目原样复制，补丁中只应包含少量原始字节
+				data := 0
+				for _, op := range manifest.Archive {
+					data += len(op.Data)
+				}
+				if info, _ := os.Stat(src); int64(data) > info.Size()/2 {
+					t.Fatalf("补丁包含 %d 字节原始数据，压缩包共 %d 字节", data, info.Size())
+				}
+			}
+
+			restored := filepath.Join(dir, "restored")
+			if err := revertFile(modified, restored, manifest); err != nil {
+				t.Fatal(err)
+			}
+			if hash, _ := sha256File(restored); hash != inputHash {
+				t.Fatalf("还原结果 %s，原始文件 %s", hash, inputHash)
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+/******************* 多模式匹配自动机 *******************/
+// Aho-Corasick 自动机：一次扫描找出所有搜索序列的全部出现位置（允许重叠）
+type acMatcher struct {
+	next     [][256]int32 // 补全失配转移后的状态转移表
+	out      [][]int32    // 到达该状态时匹配完成的序列编号（含后缀链上的序列）
+	patterns [][]byte
+	maxLen   int
+}
+
+func newACMatcher(patterns [][]byte) *acMatcher {
+	m := &acMatcher{next: make([][256]int32, 1), out: make([][]int32, 1), patterns: patterns}
+
+	// 构建字典树，0 号状态为根，转移值 0 表示暂无子节点
+	for id, p := range patterns {
+		if len(p) > m.maxLen {
+			m.maxLen = len(p)
+		}
+		state := int32(0)
+		for _, b := range p {
+			if m.next[state][b] == 0 {
+				m.next = append(m.next, [256]int32{})
+				m.out = append(m.out, nil)
+				m.next[state][b] = int32(len(m.next) - 1)
+			}
+			state = m.next[state][b]
+		}
+		m.out[state] = append(m.out[state], int32(id))
+	}
+
+	// 按层遍历计算失配指针，并把失配转移直接补进转移表
+	fail := make([]int32, len(m.next))
+	queue := make([]int32, 0, len(m.next))
+	for b := 0; b < 256; b++ {
+		if child := m.next[0][b]; child != 0 {
+			queue = append(queue, child)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		m.out[state] = append(m.out[state], m.out[fail[state]]...)
+		for b := 0; b < 256; b++ {
+			child := m.next[state][b]
+			if child == 0 {
+				m.next[state][b] = m.next[fail[state]][b]
+				continue
+			}
+			fail[child] = m.next[fail[state]][b]
+			queue = append(queue, child)
+		}
+	}
+	return m
+}
+
+// 扫描 content，对每处匹配调用 found(序列编号, 起始偏移)，同一序列按偏移从小到大报告
+func (m *acMatcher) scan(content []byte, base int, found func(id int, offset int)) {
+	m.scanFrom(0, content, base, found)
+}
+
+// 从 state 继续扫描并返回结束时的状态，分块读取时把上一块的状态传入，跨块的匹配不会遗漏
+func (m *acMatcher) scanFrom(state int32, content []byte, base int, found func(id int, offset int)) int32 {
+	for i, b := range content {
+		state = m.next[state][b]
+		for _, id := range m.out[state] {
+			found(int(id), base+i-len(m.patterns[id])+1)
+		}
+	}
+	return state
+}
+
+/******************* 搜索序列位置索引 *******************/
+// 记录内容中每个搜索序列的全部出现位置，内容被修改后只重新扫描受影响的区域
+// 内容可以是内存中的缓冲区，也可以是超出内存预算、直接在磁盘上修改的文件
+type patternIndex struct {
+	content []byte   // 内存中的内容，在磁盘上修改时为 nil
+	file    *os.File // 在磁盘上修改的文件
+	size    int
+	matcher *acMatcher
+	ids     map[string]int // 十六进制序列 -> 序列编号
+	offsets [][]int        // 每个序列按偏移排序的全部出现位置（允许重叠）
+	err     error          // 读写文件时遇到的第一个错误，出错后不再写入
+}
+
+func newPatternIndex(content []byte, codes []CodePair) *patternIndex {
+	idx := newEmptyIndex(codes)
+	idx.content, idx.size = content, len(content)
+	idx.matcher.scan(content, 0, func(id, offset int) {
+		idx.offsets[id] = append(idx.offsets[id], offset)
+	})
+	return idx
+}
+
+// 分块读取文件建立索引，每次只在内存中保留一个分块
+func newFilePatternIndex(f *os.File, size int64, codes []CodePair) (*patternIndex, error) {
+	idx := newEmptyIndex(codes)
+	idx.file, idx.size = f, int(size)
+
+	state := int32(0)
+	chunk := make([]byte, streamChunkSize)
+	for start := int64(0); start < size; start += streamChunkSize {
+		n, err := f.ReadAt(chunk, start)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		state = idx.matcher.scanFrom(state, chunk[:n], int(start), func(id, offset int) {
+			idx.offsets[id] = append(idx.offsets[id], offset)
+		})
+	}
+	return idx, nil
+}
+
+func newEmptyIndex(codes []CodePair) *patternIndex {
+	idx := &patternIndex{ids: make(map[string]int)}
+	patterns := make([][]byte, 0, len(codes)*2)
+	for _, pair := range codes {
+		for _, h := range []string{pair.HexA, pair.HexB} {
+			if _, ok := idx.ids[h]; ok {
+				continue
+			}
+			seq, _ := hex.DecodeString(h)
+			idx.ids[h] = len(patterns)
+			patterns = append(patterns, seq)
+		}
+	}
+
+	idx.matcher = newACMatcher(patterns)
+	idx.offsets = make([][]int, len(patterns))
+	return idx
+}
+
+// 按策略选出序列的偏移，结果与在当前内容上调用 findOccurrences 一致
+func (idx *patternIndex) find(h string, strategy MatchStrategy) []int {
+	id := idx.ids[h]
+	all := idx.offsets[id]
+	if len(all) == 0 {
+		return nil
+	}
+
+	switch strategy.Mode {
+	case MatchFirst:
+		return []int{all[0]}
+
+	case MatchAll, MatchNth, MatchRange:
+		// 与 findOccurrences 相同，从左到右取互不重叠的出现位置
+		seqLen := len(idx.matcher.patterns[id])
+		offsets := make([]int, 0)
+		count, pos := 0, 0
+		for _, offset := range all {
+			if offset < pos {
+				continue
+			}
+			pos = offset + seqLen
+			count++
+
+			switch strategy.Mode {
+			case MatchNth:
+				if count == strategy.N {
+					return []int{offset}
+				}
+			case MatchRange:
+				if offset >= strategy.End {
+					return offsets
+				}
+				if offset >= strategy.Start {
+					offsets = append(offsets, offset)
+				}
+			default:
+				offsets = append(offsets, offset)
+			}
+		}
+		if strategy.Mode == MatchNth {
+			return nil
+		}
+		return offsets
+
+	default:
+		return []int{all[len(all)-1]}
+	}
+}
+
+// 在 offset 处写入 seq，之后需调用 refresh 更新索引
+func (idx *patternIndex) write(offset int, seq []byte) {
+	if idx.err != nil {
+		return
+	}
+	if idx.file != nil {
+		_, idx.err = idx.file.WriteAt(seq, int64(offset))
+		return
+	}
+	copy(idx.content[offset:offset+len(seq)], seq)
+}
+
+// 读取 [start, end) 区间的当前内容
+func (idx *patternIndex) window(start, end int) []byte {
+	if idx.file == nil {
+		return idx.content[start:end]
+	}
+	buf := make([]byte, end-start)
+	if _, err := idx.file.ReadAt(buf, int64(start)); err != nil && err != io.EOF {
+		idx.err = err
+		return nil
+	}
+	return buf
+}
+
+// [start, end) 区间被修改后，重新扫描所有可能与该区间重叠的出现位置
+func (idx *patternIndex) refresh(start, end int) {
+	windowStart := start - idx.matcher.maxLen + 1
+	if windowStart < 0 {
+		windowStart = 0
+	}
+	windowEnd := end + idx.matcher.maxLen - 1
+	if windowEnd > idx.size {
+		windowEnd = idx.size
+	}
+
+	content := idx.window(windowStart, windowEnd)
+	if idx.err != nil {
+		return
+	}
+	found := make([][]int, len(idx.offsets))
+	idx.matcher.scan(content, windowStart, func(id, offset int) {
+		found[id] = append(found[id], offset)
+	})
+
+	for id, seq := range idx.matcher.patterns {
+		// 起始偏移在 [start-len+1, end) 内的出现位置与修改区间重叠，用新扫描结果替换
+		from, to := start-len(seq)+1, end
+		offsets := idx.offsets[id]
+		i := sort.SearchInts(offsets, from)
+		j := sort.SearchInts(offsets, to)
+		replaced := make([]int, 0, len(found[id]))
+		for _, offset := range found[id] {
+			if offset >= from && offset < to {
+				replaced = append(replaced, offset)
+			}
+		}
+		if i == j && len(replaced) == 0 {
+			continue
+		}
+		idx.offsets[id] = append(offsets[:i], append(replaced, offsets[j:]...)...)
+	}
+}
+
+/******************* 在同一缓冲区上交换代码对 *******************/
+// 按 opts 的交换方式在 idx 上应用所有代码对，严格模式下遇到未找到的代码对立即返回错误；
+// 读写文件出错时返回 idx.err
+func swapPairs(idx *patternIndex, file string, codes []CodePair, opts *BeautifyOptions) ([]SwapRecord, error) {
+	var claims swapClaims
+	if opts.Semantics == SemanticsSimultaneous {
+		claims = make(swapClaims)
+	}
+	records := make([]SwapRecord, 0, len(codes))
+	for _, pair := range codes {
+		var offsetsA, offsetsB []int
+		var err error
+		if claims != nil {
+			offsetsA, offsetsB, err = claimPair(idx, pair, claims)
+		} else {
+			offsetsA, offsetsB, err = swapPairInPlace(idx, pair)
+		}
+		if idx.err != nil {
+			return records, idx.err
+		}
+		record := SwapRecord{File: file, Pair: pair, OffsetsA: offsetsA, OffsetsB: offsetsB, Found: err == nil}
+		if err != nil {
+			record.Error = err.Error()
+			records = append(records, record)
+			if opts.Policy != PolicyLenient {
+				return records, fmt.Errorf("%s: %s: %s", file, pair, record.Error)
+			}
+			continue
+		}
+		records = append(records, record)
+	}
+
+	// 同时交换：所有代码对都按原始内容分配完位置后再一次性写入
+	if claims != nil {
+		for _, record := range records {
+			if record.Found {
+				idx.writePair(record.Pair, record.OffsetsA, record.OffsetsB)
+			}
+		}
+	}
+	return records, idx.err
+}
+
+// 依次交换：与逐个代码对复制内容后交换的结果一致，但直接修改 idx 的内容，不复制文件内容，
+// 修改后只刷新受影响的区域；未交换时返回的错误为给用户的原因
+func swapPairInPlace(idx *patternIndex, pair CodePair) ([]int, []int, error) {
+	offsetsA := idx.find(pair.HexA, pair.Strategy)
+	offsetsB := idx.find(pair.HexB, pair.Strategy)
+	if len(offsetsA) == 0 || len(offsetsB) == 0 {
+		return offsetsA, offsetsB, errors.New(missingReason(pair, offsetsA, offsetsB))
+	}
+
+	idx.writePair(pair, offsetsA, offsetsB)
+	idx.refreshAll(append(append([]int(nil), offsetsA...), offsetsB...), len(pair.HexA)/2)
+	return offsetsA, offsetsB, nil
+}
+
+// 同时交换：只按原始内容分配写入位置，不修改内容
+func claimPair(idx *patternIndex, pair CodePair, claims swapClaims) ([]int, []int, error) {
+	offsetsA, offsetsB, reason := claimPairOffsets(claims, pair, idx.find(pair.HexA, pair.Strategy), idx.find(pair.HexB, pair.Strategy))
+	if reason != "" {
+		return offsetsA, offsetsB, errors.New(reason)
+	}
+	return offsetsA, offsetsB, nil
+}
+
+// 在 A 的位置写入 B，在 B 的位置写入 A
+func (idx *patternIndex) writePair(pair CodePair, offsetsA, offsetsB []int) {
+	seqA := idx.matcher.patterns[idx.ids[pair.HexA]]
+	seqB := idx.matcher.patterns[idx.ids[pair.HexB]]
+	for _, offset := range offsetsA {
+		idx.write(offset, seqB)
+	}
+	for _, offset := range offsetsB {
+		idx.write(offset, seqA)
+	}
+}
+
+// 合并相互重叠的修改区间后逐个刷新索引
+func (idx *patternIndex) refreshAll(offsets []int, size int) {
+	sort.Ints(offsets)
+	start, end := offsets[0], offsets[0]+size
+	for _, offset := range offsets[1:] {
+		if offset > end {
+			idx.refresh(start, end)
+			start = offset
+		}
+		end = offset + size
+	}
+	idx.refresh(start, end)
+}
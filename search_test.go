@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 生成 size 字节的随机文件内容和 n 个代码对，每个代码对的两个代码都在内容中出现
+func benchmarkInput(size, n int) ([]byte, []CodePair) {
+	r := rand.New(rand.NewSource(1))
+	content := make([]byte, size)
+	r.Read(content)
+
+	codes := make([]CodePair, 0, n)
+	for i := 0; i < n; i++ {
+		a, b := 0x10000000+i*2, 0x10000000+i*2+1
+		pair, err := parsePairLine(fmt.Sprintf("%d %d", a, b))
+		if err != nil {
+			panic(err)
+		}
+		for _, value := range []int{a, b} {
+			offset := r.Intn(size - 4)
+			content[offset], content[offset+1], content[offset+2], content[offset+3] =
+				byte(value), byte(value>>8), byte(value>>16), byte(value>>24)
+		}
+		codes = append(codes, pair)
+	}
+	return content, codes
+}
+
+// 参考实现：按匹配策略交换 A、B 两个序列，返回修改后的内容及 A、B 被替换的偏移
+func modifyFileHex(fileContent []byte, A, B string, strategy MatchStrategy) ([]byte, []int, []int, error) {
+	searchSeq1, _ := hex.DecodeString(A)
+	searchSeq2, _ := hex.DecodeString(B)
+
+	offsets1 := findOccurrences(fileContent, searchSeq1, strategy)
+	offsets2 := findOccurrences(fileContent, searchSeq2, strategy)
+
+	if len(offsets1) == 0 || len(offsets2) == 0 {
+		return nil, offsets1, offsets2, errors.New("未找到指定的搜索序列")
+	}
+
+	newContent := make([]byte, len(fileContent))
+	copy(newContent, fileContent)
+
+	for _, index1 := range offsets1 {
+		copy(newContent[index1:index1+len(searchSeq2)], searchSeq2)
+	}
+	for _, index2 := range offsets2 {
+		copy(newContent[index2:index2+len(searchSeq1)], searchSeq1)
+	}
+
+	return newContent, offsets1, offsets2, nil
+}
+
+// 原有做法：每个代码对各扫描两次并复制一份完整的文件内容
+func applyCodePairsSequential(content []byte, codes []CodePair) []byte {
+	for _, pair := range codes {
+		if modified, _, _, err := modifyFileHex(content, pair.HexA, pair.HexB, pair.Strategy); err == nil {
+			content = modified
+		}
+	}
+	return content
+}
+
+var benchmarkSizes = []struct {
+	name  string
+	size  int
+	pairs int
+}{
+	{"1MB_20pairs", 1 << 20, 20},
+	{"1MB_200pairs", 1 << 20, 200},
+	{"30MB_200pairs", 30 << 20, 200},
+}
+
+func BenchmarkModifyFileHex(b *testing.B) {
+	for _, bm := range benchmarkSizes {
+		content, codes := benchmarkInput(bm.size, bm.pairs)
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				applyCodePairsSequential(content, codes)
+			}
+		})
+	}
+}
+
+func BenchmarkApplyCodePairs(b *testing.B) {
+	opts := &BeautifyOptions{Policy: PolicyLenient}
+	for _, bm := range benchmarkSizes {
+		content, codes := benchmarkInput(bm.size, bm.pairs)
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				applyCodePairs(content, "bench.dat", codes, opts)
+			}
+		})
+	}
+}
+
+// 超出内存预算的文件走 patchFile 的磁盘路径
+func BenchmarkPatchFileOnDisk(b *testing.B) {
+	opts := &BeautifyOptions{Policy: PolicyLenient}
+	for _, bm := range benchmarkSizes {
+		content, codes := benchmarkInput(bm.size, bm.pairs)
+		path := filepath.Join(b.TempDir(), "bench.dat")
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if err := os.WriteFile(path, content, 0644); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+				if _, _, err := patchFileOnDisk(path, "bench.dat", codes, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// 随机的匹配策略，覆盖所有模式
+func randomStrategy(r *rand.Rand, size int) MatchStrategy {
+	switch r.Intn(5) {
+	case 0:
+		return MatchStrategy{Mode: MatchLast}
+	case 1:
+		return MatchStrategy{Mode: MatchFirst}
+	case 2:
+		return MatchStrategy{Mode: MatchAll}
+	case 3:
+		return MatchStrategy{Mode: MatchNth, N: 1 + r.Intn(4)}
+	default:
+		start := r.Intn(size + 1)
+		return MatchStrategy{Mode: MatchRange, Start: start, End: start + r.Intn(size+1)}
+	}
+}
+
+// 由少量字节值组成的随机序列，使匹配频繁出现并相互重叠
+func randomBytes(r *rand.Rand, n, alphabet int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(r.Intn(alphabet))
+	}
+	return b
+}
+
+func equalOffsets(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// applyCodePairs 的结果与逐个调用 modifyFileHex 一致
+func TestApplyCodePairsMatchesModifyFileHex(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for iter := 0; iter < 2000; iter++ {
+		size := r.Intn(512)
+		content := randomBytes(r, size, 2+r.Intn(6))
+		codes := make([]CodePair, 1+r.Intn(5))
+		for i := range codes {
+			width := 1 + r.Intn(3)
+			codes[i] = CodePair{
+				HexA:     hex.EncodeToString(randomBytes(r, width, 4)),
+				HexB:     hex.EncodeToString(randomBytes(r, width, 4)),
+				Strategy: randomStrategy(r, size),
+			}
+		}
+
+		got, records, err := applyCodePairs(content, "test.dat", codes, &BeautifyOptions{Policy: PolicyLenient})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := content
+		for i, pair := range codes {
+			modified, offsetsA, offsetsB, err := modifyFileHex(want, pair.HexA, pair.HexB, pair.Strategy)
+			if records[i].Found != (err == nil) {
+				t.Fatalf("第 %d 次：代码对 %d 的 Found = %v，modifyFileHex 返回 %v", iter, i, records[i].Found, err)
+			}
+			if err != nil {
+				continue
+			}
+			if !equalOffsets(records[i].OffsetsA, offsetsA) || !equalOffsets(records[i].OffsetsB, offsetsB) {
+				t.Fatalf("第 %d 次：代码对 %d 的偏移 %v %v，期望 %v %v", iter, i, records[i].OffsetsA, records[i].OffsetsB, offsetsA, offsetsB)
+			}
+			want = modified
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("第 %d 次：结果不一致\n内容 %x\n代码对 %+v\n得到 %x\n期望 %x", iter, content, codes, got, want)
+		}
+
+		// 严格模式在第一个未找到的代码对处出错
+		_, strictRecords, err := applyCodePairs(content, "test.dat", codes, defaultBeautifyOptions())
+		if missing := !strictRecords[len(strictRecords)-1].Found; (err != nil) != missing {
+			t.Fatalf("第 %d 次：严格模式返回 %v，最后一个记录 Found = %v", iter, err, !missing)
+		}
+	}
+}
+
+// scanOccurrences 分块查找的结果与 findOccurrences 一致，包括跨越分块边界的匹配
+func TestScanOccurrencesMatchesFindOccurrences(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for iter := 0; iter < 40; iter++ {
+		seq := randomBytes(r, 1+r.Intn(8), 256)
+		size := streamChunkSize - 16 + r.Intn(streamChunkSize+32)
+		content := randomBytes(r, size, 256)
+		// 在分块边界附近和随机位置放置搜索序列，部分相互重叠
+		for _, pos := range []int{streamChunkSize - len(seq) + 1 + r.Intn(len(seq)), streamChunkSize - len(seq), r.Intn(size), r.Intn(size), 2*streamChunkSize - 1} {
+			if pos >= 0 && pos+len(seq) <= size {
+				copy(content[pos:], seq)
+			}
+		}
+
+		for n := 0; n < 8; n++ {
+			strategy := randomStrategy(r, size)
+			want := findOccurrences(content, seq, strategy)
+			got, err := scanOccurrences(bytes.NewReader(content), int64(size), seq, strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalOffsets(got, want) {
+				t.Fatalf("第 %d 次：序列 %x 策略 %s 得到 %v，期望 %v", iter, seq, strategy, got, want)
+			}
+		}
+	}
+
+	// 重复字节组成的序列在分块内外大量重叠
+	for iter := 0; iter < 20; iter++ {
+		seq := bytes.Repeat([]byte{1}, 1+r.Intn(4))
+		content := randomBytes(r, 2*streamChunkSize+r.Intn(64), 3)
+		for n := 0; n < 4; n++ {
+			strategy := randomStrategy(r, len(content))
+			want := findOccurrences(content, seq, strategy)
+			got, err := scanOccurrences(bytes.NewReader(content), int64(len(content)), seq, strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalOffsets(got, want) {
+				t.Fatalf("序列 %x 策略 %s 的结果不一致：得到 %d 处，期望 %d 处", seq, strategy, len(got), len(want))
+			}
+		}
+	}
+}
+
+// 超出内存预算的文件在磁盘上修改，结果与 applyCodePairs 一致，包括跨越分块边界的匹配
+func TestPatchFileOnDiskMatchesApplyCodePairs(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	path := filepath.Join(t.TempDir(), "test.dat")
+	for iter := 0; iter < 6; iter++ {
+		size := streamChunkSize + r.Intn(streamChunkSize)
+		content := randomBytes(r, size, 16)
+		codes := make([]CodePair, 1+r.Intn(6))
+		for i := range codes {
+			a := randomBytes(r, 3+r.Intn(2), 16)
+			b := randomBytes(r, len(a), 16)
+			copy(content[streamChunkSize-1-r.Intn(len(a)-1):], a)
+			codes[i] = CodePair{HexA: hex.EncodeToString(a), HexB: hex.EncodeToString(b), Strategy: randomStrategy(r, size)}
+		}
+		opts := &BeautifyOptions{Policy: PolicyLenient}
+		if iter%2 == 1 {
+			opts.Semantics = SemanticsSimultaneous
+		}
+
+		want, wantRecords, _ := applyCodePairs(content, "test.dat", codes, opts)
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		gotRecords, _, err := patchFileOnDisk(path, "test.dat", codes, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("第 %d 次：磁盘上修改的结果与内存中不一致", iter)
+		}
+		for i := range wantRecords {
+			if gotRecords[i].Found != wantRecords[i].Found || !equalOffsets(gotRecords[i].OffsetsA, wantRecords[i].OffsetsA) || !equalOffsets(gotRecords[i].OffsetsB, wantRecords[i].OffsetsB) {
+				t.Fatalf("第 %d 次：代码对 %d 的记录不一致", iter, i)
+			}
+		}
+	}
+}
+
+// 同时交换的链式代码对（A↔B、B↔C）在严格模式下不会失败：都按原始内容查找，B 的位置归前一个代码对，C 换成 B
+func TestSimultaneousChainedPairs(t *testing.T) {
+	all := MatchStrategy{Mode: MatchAll}
+	codes := []CodePair{
+		{Original: "A", New: "B", HexA: "1111", HexB: "2222", Strategy: all},
+		{Original: "B", New: "C", HexA: "2222", HexB: "3333", Strategy: all},
+	}
+	content := []byte{0x11, 0x11, 0x00, 0x22, 0x22, 0x00, 0x33, 0x33}
+	want := []byte{0x22, 0x22, 0x00, 0x11, 0x11, 0x00, 0x22, 0x22}
+	opts := &BeautifyOptions{Policy: PolicyStrict, Semantics: SemanticsSimultaneous}
+
+	got, records, err := applyCodePairs(content, "test.dat", codes, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("得到 %x，期望 %x", got, want)
+	}
+	if !records[1].Found || len(records[1].OffsetsA) != 0 || !equalOffsets(records[1].OffsetsB, []int{6}) {
+		t.Fatalf("第二个代码对的记录 %+v", records[1])
+	}
+
+	path := filepath.Join(t.TempDir(), "test.dat")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := patchFileOnDisk(path, "test.dat", codes, opts); err != nil {
+		t.Fatal(err)
+	}
+	if onDisk, _ := os.ReadFile(path); !bytes.Equal(onDisk, want) {
+		t.Fatalf("磁盘上得到 %x，期望 %x", onDisk, want)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// 每个任务的内存预算：整体读入内存处理需要约两倍文件大小，预算由并发处理的文件平分，超出的文件改为在磁盘上分块处理
+var jobMemoryBudget = int64(64 * 1024 * 1024) // 64MB
+
+const streamChunkSize = 1024 * 1024 // 分块扫描时每次读取的字节数
+
+/******************* 按内存预算修改文件 *******************/
+// 在 path 上原地应用代码对，没有任何字节被修改时返回的补丁为 nil
+// 严格模式下出错时文件保持原样
+func patchFile(path, relPath string, codes []CodePair, opts *BeautifyOptions) ([]SwapRecord, *PatchFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Size() > fileMemoryLimit() {
+		return patchFileOnDisk(path, relPath, codes, opts)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	modified, records, err := applyCodePairs(content, relPath, codes, opts)
+	// 代码对找到但交换后字节不变（如两段代码相同）也视为未修改
+	if err != nil || bytes.Equal(content, modified) {
+		return records, nil, err
+	}
+	if err := os.WriteFile(path, modified, info.Mode()); err != nil {
+		return records, nil, err
+	}
+	patch := diffFile(relPath, content, modified)
+	return records, &patch, nil
+}
+
+// 超出内存预算的文件：分块扫描匹配位置，再按偏移原地写入
+func patchFileOnDisk(path, relPath string, codes []CodePair, opts *BeautifyOptions) ([]SwapRecord, *PatchFile, error) {
+	// 保留一份原始副本用于生成补丁和出错时恢复，放在解压目录之外，避免与包内同名文件冲突
+	backupFile, err := ioutil.TempFile("", "patch_backup_*")
+	if err != nil {
+		return nil, nil, err
+	}
+	backup := backupFile.Name()
+	backupFile.Close()
+	defer os.Remove(backup)
+	if err := copyFile(path, backup); err != nil {
+		return nil, nil, err
+	}
+
+	records, err := swapOnDisk(path, relPath, codes, opts)
+	if err != nil {
+		if restoreErr := copyFile(backup, path); restoreErr != nil {
+			return records, nil, restoreErr
+		}
+		return records, nil, err
+	}
+	if !anyFound(records) {
+		return records, nil, nil
+	}
+
+	patch, err := diffFiles(relPath, backup, path)
+	if err != nil || len(patch.Patches) == 0 {
+		return records, nil, err
+	}
+	return records, &patch, nil
+}
+
+// 与 applyCodePairs 语义一致，分块扫描一次建立所有代码对的位置索引，之后按偏移原地写入并只重读受影响的区域
+func swapOnDisk(path, relPath string, codes []CodePair, opts *BeautifyOptions) ([]SwapRecord, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	index, err := newFilePatternIndex(f, info.Size(), codes)
+	if err != nil {
+		return nil, err
+	}
+
+	return swapPairs(index, relPath, codes, opts)
+}
+
+/******************* 分块查找匹配位置 *******************/
+// 与 findOccurrences 结果一致，每次只读取一个分块（相邻分块重叠 len(seq)-1 字节）
+func scanOccurrences(r io.ReaderAt, size int64, seq []byte, strategy MatchStrategy) ([]int, error) {
+	if len(seq) == 0 {
+		return nil, nil
+	}
+
+	offsets := make([]int, 0)
+	last, count, next := -1, 0, 0
+	window := make([]byte, streamChunkSize+len(seq)-1)
+	for start := int64(0); start < size; start += streamChunkSize {
+		n, err := r.ReadAt(window, start)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		data := window[:n]
+
+		// 只处理起始位置落在本分块内的匹配，避免重叠区域重复计数
+		for i := 0; ; {
+			j := bytes.Index(data[i:], seq)
+			if j < 0 || i+j >= streamChunkSize {
+				break
+			}
+			offset := int(start) + i + j
+			i += j + 1
+
+			switch strategy.Mode {
+			case MatchFirst:
+				return []int{offset}, nil
+			case MatchAll, MatchNth, MatchRange:
+				// 与逐个查找一致，匹配之间不重叠
+				if offset < next {
+					continue
+				}
+				next = offset + len(seq)
+				switch strategy.Mode {
+				case MatchNth:
+					if count++; count == strategy.N {
+						return []int{offset}, nil
+					}
+				case MatchRange:
+					if offset >= strategy.End {
+						return offsets, nil
+					}
+					if offset >= strategy.Start {
+						offsets = append(offsets, offset)
+					}
+				default:
+					offsets = append(offsets, offset)
+				}
+			default:
+				last = offset
+			}
+		}
+	}
+
+	switch strategy.Mode {
+	case MatchFirst, MatchNth:
+		return nil, nil
+	case MatchAll, MatchRange:
+		return offsets, nil
+	}
+	if last < 0 {
+		return nil, nil
+	}
+	return []int{last}, nil
+}
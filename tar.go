@@ -0,0 +1,298 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 支持的压缩包格式
+const (
+	formatZip   = "zip"
+	formatTar   = "tar"
+	formatTarGz = "tar.gz"
+	formatGzip  = "gz"
+)
+
+// 按文件名判断压缩包格式，不是压缩包时返回空字符串
+func archiveFormat(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return formatZip
+	case strings.HasSuffix(lower, ".tar"):
+		return formatTar
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return formatTarGz
+	case strings.HasSuffix(lower, ".gz"):
+		return formatGzip
+	}
+	return ""
+}
+
+/******************* 解压 *******************/
+// name 为压缩包的原始文件名，gzip 头中没有记录文件名时据此命名解压出的文件
+// budget 为任务剩余的解压额度，解压出的字节数和条目数从中扣除
+func extractArchive(format, src, name, dest string, budget *extractBudget) error {
+	switch format {
+	case formatZip:
+		return unzip(src, dest, budget)
+	case formatTar, formatTarGz:
+		return untar(src, format == formatTarGz, dest, budget)
+	case formatGzip:
+		return gunzip(src, name, dest, budget)
+	}
+	return fmt.Errorf("不支持的压缩包格式: %s", format)
+}
+
+func untar(src string, compressed bool, dest string, budget *extractBudget) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	compressedSize := int64(0)
+	if compressed {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r, compressedSize = gr, info.Size()
+	}
+
+	tr := tar.NewReader(r)
+	written := int64(0)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := budget.addEntry(); err != nil {
+			return err
+		}
+		target, err := safeJoin(dest, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			// 整个 gzip 流的压缩比在写入前就限制读取量，不会先写出超限的数据再检查
+			limit, ratioCapped := budget.bytes, false
+			if compressed {
+				limit, ratioCapped = gzipStreamLimit(budget, compressedSize, written)
+			}
+			n, err := writeEntryFile(target, tr, limit)
+			budget.bytes -= n
+			written += n
+			if err != nil {
+				if ratioCapped && errors.Is(err, errUnsafeArchive) {
+					return errCompressionRatio()
+				}
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+			// PAX 全局头不对应任何文件
+		case tar.TypeSymlink, tar.TypeLink:
+			return fmt.Errorf("%w：不允许包含链接 %s", errUnsafeArchive, header.Name)
+		default:
+			return fmt.Errorf("%w：不支持的条目类型 %s", errUnsafeArchive, header.Name)
+		}
+	}
+}
+
+func gunzip(src, name, dest string, budget *extractBudget) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	if err := budget.addEntry(); err != nil {
+		return err
+	}
+	limit, ratioCapped := gzipStreamLimit(budget, info.Size(), 0)
+	n, err := writeEntryFile(filepath.Join(dest, gzipEntryName(gr.Header, name)), gr, limit)
+	budget.bytes -= n
+	if ratioCapped && errors.Is(err, errUnsafeArchive) {
+		return errCompressionRatio()
+	}
+	return err
+}
+
+// gzip 流还能解压出的字节数：剩余额度与按压缩比上限计算的剩余字节数中较小者，ratioCapped 表示受压缩比限制
+func gzipStreamLimit(budget *extractBudget, compressedSize, written int64) (limit int64, ratioCapped bool) {
+	ratioLimit, maxOutput := uint64(0), uint64(compressedSize)*maxCompressionRatio
+	if uint64(written) < maxOutput {
+		ratioLimit = maxOutput - uint64(written)
+	}
+	if ratioLimit < uint64(budget.bytes) {
+		return int64(ratioLimit), true
+	}
+	return budget.bytes, false
+}
+
+func errCompressionRatio() error {
+	return fmt.Errorf("%w：压缩比超过 %d 倍", errUnsafeArchive, maxCompressionRatio)
+}
+
+// 解压出的文件名：优先使用 gzip 头中记录的文件名（只取最后一段），否则去掉压缩包的 .gz 后缀
+func gzipEntryName(header gzip.Header, name string) string {
+	if base := path.Base(strings.ReplaceAll(header.Name, "\\", "/")); header.Name != "" && base != "." && base != ".." && base != "/" {
+		return base
+	}
+	base := filepath.Base(name)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+/******************* 重建压缩包 *******************/
+func repackArchive(format, src, name, workDir string, changed map[string]bool, out io.Writer) error {
+	switch format {
+	case formatZip:
+		return repackZip(src, workDir, changed, out)
+	case formatTar, formatTarGz:
+		return repackTarFile(src, format == formatTarGz, workDir, changed, out)
+	case formatGzip:
+		return repackGzip(src, name, workDir, changed, out)
+	}
+	return fmt.Errorf("不支持的压缩包格式: %s", format)
+}
+
+// tar.gz 沿用原有的 gzip 头（文件名、时间、注释等）重新压缩
+func repackTarFile(src string, compressed bool, workDir string, changed map[string]bool, out io.Writer) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if !compressed {
+		return repackTar(f, workDir, changed, out)
+	}
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	gw := gzip.NewWriter(out)
+	gw.Header = gr.Header
+	if err := repackTar(gr, workDir, changed, gw); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// 按原 tar 的条目顺序写出：条目头（权限、时间、属主、PAX 记录等）原样保留，修改过的文件只替换内容
+func repackTar(r io.Reader, workDir string, changed map[string]bool, out io.Writer) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(out)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name, err := sanitizeEntryPath(header.Name)
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeReg && changed[name] {
+			err = writeTarEntry(tw, header, filepath.Join(workDir, filepath.FromSlash(name)))
+		} else if err = tw.WriteHeader(header); err == nil {
+			_, err = io.Copy(tw, tr)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// 以原条目头写入磁盘上修改后的内容
+func writeTarEntry(tw *tar.Writer, header *tar.Header, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header.Size = info.Size()
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// 单文件 gzip：未修改时原样复制，否则沿用原有的 gzip 头重新压缩
+func repackGzip(src, name, workDir string, changed map[string]bool, out io.Writer) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if len(changed) == 0 {
+		_, err := io.Copy(out, f)
+		return err
+	}
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	content, err := os.Open(filepath.Join(workDir, gzipEntryName(gr.Header, name)))
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	gw := gzip.NewWriter(out)
+	gw.Header = gr.Header
+	if _, err := io.Copy(gw, content); err != nil {
+		return err
+	}
+	return gw.Close()
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+//go:embed web/templates/*.html web/static/*
+var webFS embed.FS
+
+const (
+	loginTokenTTL     = 5 * time.Minute // 一次性登录链接有效期
+	webSessionTTL     = 12 * time.Hour  // 后台登录会话有效期
+	webSessionCookie  = "tgbot_session"
+	webUsersPerPage   = 50
+	webChartDays      = 30
+	webRecentJobLimit = 100
+)
+
+// 登录凭据（一次性链接或会话）
+type webCredential struct {
+	AdminID   int64
+	ExpiresAt time.Time
+}
+
+var (
+	loginTokens   = make(map[string]webCredential)
+	webSessions   = make(map[string]webCredential)
+	webMu         sync.Mutex
+	webTemplates  = make(map[string]*template.Template)
+	webTemplFuncs = template.FuncMap{
+		"formatTime": func(t time.Time) string {
+			if t.IsZero() {
+				return "-"
+			}
+			return t.Format("2006-01-02 15:04:05")
+		},
+		"formatPoints": func(p float64) string { return fmt.Sprintf("%.2f", p) },
+	}
+)
+
+/******************* 启动网页管理后台 *******************/
+func startWebServer(bot *tgbotapi.BotAPI) {
+	pages := []string{"users.html", "codes.html", "jobs.html", "charts.html", "message.html"}
+	for _, page := range pages {
+		tmpl, err := template.New(page).Funcs(webTemplFuncs).ParseFS(webFS, "web/templates/layout.html", "web/templates/"+page)
+		if err != nil {
+			log.Printf("解析后台模板失败: %v", err)
+			return
+		}
+		webTemplates[page] = tmpl
+	}
+
+	staticFS, _ := fs.Sub(webFS, "web/static")
+
+	mux := http.NewServeMux()
+	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticFS))))
+	mux.HandleFunc("/login", webLoginHandler)
+	mux.HandleFunc("/logout", webLogoutHandler)
+	mux.HandleFunc("/", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/users", http.StatusFound)
+	}))
+	mux.HandleFunc("/users", requireAdmin(webUsersHandler))
+	mux.HandleFunc("/codes", requireAdmin(webCodesHandler))
+	mux.HandleFunc("/jobs", requireAdmin(webJobsHandler))
+	mux.HandleFunc("/charts", requireAdmin(webChartsHandler))
+
+	log.Printf("管理后台已启动: %s", webAddr)
+	if err := http.ListenAndServe(webAddr, mux); err != nil {
+		log.Printf("管理后台运行失败: %v", err)
+	}
+}
+
+/******************* 生成一次性登录链接 *******************/
+func handleWebLogin(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	adminID := message.From.ID
+	token, err := randomToken()
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "❌ 生成登录链接失败"))
+		return
+	}
+
+	webMu.Lock()
+	pruneWebCredentialsLocked()
+	loginTokens[token] = webCredential{AdminID: adminID, ExpiresAt: time.Now().Add(loginTokenTTL)}
+	webMu.Unlock()
+
+	link := strings.TrimRight(webBaseURL, "/") + "/login?token=" + token
+	msg := tgbotapi.NewMessage(adminID, fmt.Sprintf("🔐 管理后台登录链接（%d分钟内有效，仅可使用一次）：\n%s", int(loginTokenTTL.Minutes()), link))
+	msg.DisableWebPagePreview = true
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("发送登录链接失败: %v", err)
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "❌ 无法私聊发送登录链接，请先私聊机器人"))
+		return
+	}
+
+	if message.Chat.ID != adminID {
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "✅ 登录链接已私聊发送"))
+	}
+	log.Printf("管理员申请后台登录链接: ID=%d", adminID)
+}
+
+// 签发新凭据时清理已过期的登录链接和会话，调用方需持有 webMu
+func pruneWebCredentialsLocked() {
+	now := time.Now()
+	for token, cred := range loginTokens {
+		if now.After(cred.ExpiresAt) {
+			delete(loginTokens, token)
+		}
+	}
+	for id, cred := range webSessions {
+		if now.After(cred.ExpiresAt) {
+			delete(webSessions, id)
+		}
+	}
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+/******************* 登录/退出 *******************/
+func webLoginHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	webMu.Lock()
+	cred, ok := loginTokens[token]
+	delete(loginTokens, token) // 无论是否有效，链接只能使用一次
+	webMu.Unlock()
+
+	if token == "" || !ok || time.Now().After(cred.ExpiresAt) || !isAdmin(cred.AdminID) {
+		renderMessage(w, http.StatusUnauthorized, "登录链接无效或已过期，请在机器人中重新发送 /weblogin")
+		return
+	}
+
+	sessionID, err := randomToken()
+	if err != nil {
+		renderMessage(w, http.StatusInternalServerError, "创建登录会话失败")
+		return
+	}
+
+	expiresAt := time.Now().Add(webSessionTTL)
+	webMu.Lock()
+	pruneWebCredentialsLocked()
+	webSessions[sessionID] = webCredential{AdminID: cred.AdminID, ExpiresAt: expiresAt}
+	webMu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     webSessionCookie,
+		Value:    sessionID,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   webSecureCookie(),
+		SameSite: http.SameSiteLaxMode,
+	})
+	log.Printf("管理员登录后台: ID=%d", cred.AdminID)
+	http.Redirect(w, r, "/users", http.StatusFound)
+}
+
+// 通过 https 访问后台时只在 https 下发送会话 Cookie
+func webSecureCookie() bool {
+	return strings.HasPrefix(strings.ToLower(webBaseURL), "https://")
+}
+
+func webLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(webSessionCookie); err == nil {
+		webMu.Lock()
+		delete(webSessions, cookie.Value)
+		webMu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: webSessionCookie, Value: "", Path: "/", MaxAge: -1, Secure: webSecureCookie()})
+	renderMessage(w, http.StatusOK, "已退出登录")
+}
+
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(webSessionCookie)
+		if err != nil {
+			renderMessage(w, http.StatusUnauthorized, "请在机器人中发送 /weblogin 获取登录链接")
+			return
+		}
+
+		webMu.Lock()
+		cred, ok := webSessions[cookie.Value]
+		if ok && time.Now().After(cred.ExpiresAt) {
+			delete(webSessions, cookie.Value)
+			ok = false
+		}
+		webMu.Unlock()
+
+		if !ok || !isAdmin(cred.AdminID) {
+			renderMessage(w, http.StatusUnauthorized, "登录已过期，请在机器人中重新发送 /weblogin")
+			return
+		}
+		next(w, r)
+	}
+}
+
+/******************* 页面渲染 *******************/
+func renderPage(w http.ResponseWriter, status int, page string, data map[string]interface{}) {
+	tmpl, ok := webTemplates[page]
+	if !ok {
+		http.Error(w, "页面不存在", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := tmpl.ExecuteTemplate(w, "layout", data); err != nil {
+		log.Printf("渲染后台页面失败: %v", err)
+	}
+}
+
+func renderMessage(w http.ResponseWriter, status int, text string) {
+	renderPage(w, status, "message.html", map[string]interface{}{"Title": "提示", "Message": text})
+}
+
+/******************* 用户列表 *******************/
+func webUsersHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	sortBy := r.URL.Query().Get("sort")
+	order := r.URL.Query().Get("order")
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	mu.Lock()
+	list := make([]User, 0, len(users))
+	for _, u := range users {
+		if query != "" {
+			haystack := strings.ToLower(fmt.Sprintf("%d %s %s %s", u.ID, u.Username, u.FirstName, u.LastName))
+			if !strings.Contains(haystack, query) {
+				continue
+			}
+		}
+		list = append(list, *u)
+	}
+	mu.Unlock()
+
+	less := func(i, j int) bool { return list[i].ID < list[j].ID }
+	switch sortBy {
+	case "points":
+		less = func(i, j int) bool { return list[i].Points < list[j].Points }
+	case "checkin":
+		less = func(i, j int) bool { return list[i].LastCheckIn.Before(list[j].LastCheckIn) }
+	}
+	if order == "desc" {
+		sort.SliceStable(list, func(i, j int) bool { return less(j, i) })
+	} else {
+		sort.SliceStable(list, less)
+	}
+
+	total := len(list)
+	pages := (total + webUsersPerPage - 1) / webUsersPerPage
+	if pages == 0 {
+		pages = 1
+	}
+	if page > pages {
+		page = pages
+	}
+	start := (page - 1) * webUsersPerPage
+	end := start + webUsersPerPage
+	if end > total {
+		end = total
+	}
+
+	renderPage(w, http.StatusOK, "users.html", map[string]interface{}{
+		"Title": "用户",
+		"Users": list[start:end],
+		"Total": total,
+		"Query": query,
+		"Sort":  sortBy,
+		"Order": order,
+		"Page":  page,
+		"Pages": pages,
+		"Prev":  page - 1,
+		"Next":  page + 1,
+	})
+}
+
+/******************* 卡密库存 *******************/
+func codeStatus(rc *RedeemCode, now time.Time) string {
+	switch {
+	case rc.Used:
+		return "used"
+	case now.After(rc.ExpiresAt):
+		return "expired"
+	default:
+		return "unused"
+	}
+}
+
+func webCodesHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	now := time.Now()
+
+	type codeRow struct {
+		RedeemCode
+		Status string
+	}
+
+	counts := map[string]int{"unused": 0, "used": 0, "expired": 0}
+	rows := make([]codeRow, 0)
+
+	mu.Lock()
+	for _, rc := range codes {
+		st := codeStatus(rc, now)
+		counts[st]++
+		if status != "" && status != "all" && status != st {
+			continue
+		}
+		rows = append(rows, codeRow{RedeemCode: *rc, Status: st})
+	}
+	mu.Unlock()
+
+	sort.Slice(rows, func(i, j int) bool {
+		if !rows[i].CreatedAt.Equal(rows[j].CreatedAt) {
+			return rows[i].CreatedAt.After(rows[j].CreatedAt)
+		}
+		return rows[i].Code < rows[j].Code
+	})
+
+	renderPage(w, http.StatusOK, "codes.html", map[string]interface{}{
+		"Title":  "卡密",
+		"Codes":  rows,
+		"Status": status,
+		"Counts": counts,
+		"Total":  counts["unused"] + counts["used"] + counts["expired"],
+	})
+}
+
+/******************* 最近美化任务 *******************/
+func webJobsHandler(w http.ResponseWriter, r *http.Request) {
+	type jobRow struct {
+		JobRecord
+		Username string
+	}
+
+	jobs := recentJobs(webRecentJobLimit)
+	rows := make([]jobRow, 0, len(jobs))
+	mu.Lock()
+	for _, job := range jobs {
+		row := jobRow{JobRecord: *job}
+		if u, ok := users[job.UserID]; ok {
+			row.Username = u.Username
+		}
+		rows = append(rows, row)
+	}
+	mu.Unlock()
+
+	renderPage(w, http.StatusOK, "jobs.html", map[string]interface{}{
+		"Title": "美化任务",
+		"Jobs":  rows,
+	})
+}
+
+/******************* 统计图表 *******************/
+type chartBar struct {
+	Label  string
+	Value  int
+	X      int
+	Y      int
+	Height int
+}
+
+type chartData struct {
+	Title string
+	Total int
+	Max   int
+	Bars  []chartBar
+}
+
+const (
+	chartHeight   = 160
+	chartBarWidth = 18
+	chartBarGap   = 6
+)
+
+func buildChart(title string, days []string, values map[string]int) chartData {
+	chart := chartData{Title: title}
+	for _, day := range days {
+		if values[day] > chart.Max {
+			chart.Max = values[day]
+		}
+		chart.Total += values[day]
+	}
+
+	for i, day := range days {
+		h := 0
+		if chart.Max > 0 {
+			h = values[day] * chartHeight / chart.Max
+		}
+		chart.Bars = append(chart.Bars, chartBar{
+			Label:  day[5:],
+			Value:  values[day],
+			X:      i * (chartBarWidth + chartBarGap),
+			Y:      chartHeight - h,
+			Height: h,
+		})
+	}
+	return chart
+}
+
+func webChartsHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	days := make([]string, 0, webChartDays)
+	for i := webChartDays - 1; i >= 0; i-- {
+		days = append(days, now.AddDate(0, 0, -i).Format("2006-01-02"))
+	}
+
+	recordsMu.Lock()
+	checkIns := make(map[string]int, len(checkInStats))
+	for day, n := range checkInStats {
+		checkIns[day] = n
+	}
+	recordsMu.Unlock()
+
+	redemptions := make(map[string]int)
+	mu.Lock()
+	for _, rc := range codes {
+		if rc.Used && !rc.UsedAt.IsZero() {
+			redemptions[rc.UsedAt.Format("2006-01-02")]++
+		}
+	}
+	mu.Unlock()
+
+	renderPage(w, http.StatusOK, "charts.html", map[string]interface{}{
+		"Title":      "统计",
+		"ChartWidth": webChartDays * (chartBarWidth + chartBarGap),
+		"BarWidth":   chartBarWidth,
+		"Height":     chartHeight,
+		"Charts": []chartData{
+			buildChart("每日签到", days, checkIns),
+			buildChart("每日卡密兑换", days, redemptions),
+		},
+	})
+}